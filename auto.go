@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var (
+	autoCommit = flag.Bool("auto-commit", false, "Run non-interactively. Txns classified"+
+		" with confidence of at least -auto-cutoff are written out, and the rest are"+
+		" dumped to -uncategorized-out for review, which defaults to the output file"+
+		" with a .review.csv suffix.")
+	autoCutoff = flag.Float64("auto-cutoff", 0.9, "Minimum confidence, between 0 and 1, for"+
+		" -auto-commit to write out a txn.")
+)
+
+// confidence returns the top category for the txn, along with the confidence of
+// the classifier in it.
+func (p *parser) confidence(t Txn) (string, float64) {
+	scores := p.cl.Score(features(t))
+	if len(scores) == 0 {
+		return "", 0
+	}
+	top := scores[0]
+	for _, cs := range scores {
+		if cs.Score > top.Score {
+			top = cs
+		}
+	}
+	return string(top.Category), p.cl.Confidence(scores)
+}
+
+// autoCategorize writes out the txns classified with enough confidence, and
+// returns the rest.
+func (p *parser) autoCategorize(txns []Txn) []Txn {
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		cat, conf := p.confidence(t)
+		if conf < *autoCutoff {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		if t.Cur > 0 {
+			t.From = cat
+		} else {
+			t.To = cat
+		}
+		count++
+		printSummary(t, count, count)
+		p.writeToDB(t)
+	}
+	fmt.Printf("\t%d txns have been categorized with confidence of at least %.2f.\n\n",
+		count, *autoCutoff)
+	fmt.Printf("\t%d txns were left for review.\n\n", len(unmatched))
+	return unmatched
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	outFormat = flag.String("format", "ledger", "Format of txns written to output. One of:"+
+		" ledger, beancount.")
+
+	rbeanCommodity = regexp.MustCompile(`^[A-Z][A-Z0-9'._-]{0,22}[A-Z0-9]$`)
+)
+
+// formatTxn formats the txn as per -format.
+func formatTxn(t Txn) string {
+	if *outFormat == "beancount" {
+		return beancountFormat(t)
+	}
+	return ledgerFormat(t)
+}
+
+// beancountCommodity returns the commodity of the currency for beancount, which
+// only accepts names like USD. A symbol, like $, is mapped to the name of the
+// configured currency.
+func beancountCommodity(cur string) (string, error) {
+	if curConf != nil && len(curConf.Name) > 0 && (cur == curConf.Symbol || cur == curConf.Name) {
+		cur = curConf.Name
+	}
+	if !rbeanCommodity.MatchString(cur) {
+		return "", fmt.Errorf("Invalid beancount commodity %q. Expected a name like USD via -c,"+
+			" or the name of the currency in currencies.yaml", cur)
+	}
+	return cur, nil
+}
+
+// beancountAccount returns the account name for beancount, which doesn't allow
+// spaces in account names.
+func beancountAccount(account string) string {
+	return strings.Replace(account, " ", "-", -1)
+}
+
+// beancountBalance returns a balance directive for the account, as of the start of
+// the day after date. Unlike a ledger assertion, beancount checks the balance at
+// the start of the day of the directive.
+func beancountBalance(date time.Time, account string, bal float64, curName string) string {
+	prec := 2
+	if curConf != nil {
+		prec = *curConf.Precision
+	}
+	commodity, _ := beancountCommodity(curName)
+	return fmt.Sprintf("%s balance %-40s %s %s\n\n", date.AddDate(0, 0, 1).Format("2006-01-02"),
+		beancountAccount(account), strconv.FormatFloat(bal, 'f', prec, 64), commodity)
+}
+
+// beancountFormat writes the txn in beancount syntax. Like ledgerFormat, the
+// amount is written against To, and the posting for From is left for beancount
+// to balance. The currency must have been validated via beancountCommodity.
+func beancountFormat(t Txn) string {
+	var b bytes.Buffer
+	mark := statusCleared
+	if t.Status == statusPending {
+		mark = statusPending
+	}
+	b.WriteString(fmt.Sprintf("%s %s %s\n", t.Date.Format("2006-01-02"), mark,
+		strconv.Quote(t.Desc)))
+	if len(t.Note) > 0 {
+		note := strings.Replace(t.Note, "\n", " ", -1)
+		b.WriteString(fmt.Sprintf("  note: %s\n", strconv.Quote(note)))
+	}
+	if keepID(t) {
+		b.WriteString(fmt.Sprintf("  id: %s\n", strconv.Quote(t.ID)))
+	}
+
+	prec := 2
+	if curConf != nil {
+		prec = *curConf.Precision
+	}
+	commodity, _ := beancountCommodity(t.CurName)
+	posting := func(account string, amt float64) {
+		s := strconv.FormatFloat(amt, 'f', prec, 64)
+		var price string
+		if r, ok := priceOf(t); ok {
+			base, _ := beancountCommodity(*baseCurrency)
+			price = fmt.Sprintf(" @ %v %s", r, base)
+		}
+		b.WriteString(fmt.Sprintf("  %-40s %s %s%s\n", beancountAccount(account), s,
+			commodity, price))
+	}
+	switch {
+	case len(t.Splits) > 0 && t.Cur > 0:
+		posting(t.To, math.Abs(t.Cur))
+		for _, ps := range t.Splits {
+			posting(ps.Account, -ps.Amount)
+		}
+		b.WriteString("\n")
+	case len(t.Splits) > 0:
+		for _, ps := range t.Splits {
+			posting(ps.Account, ps.Amount)
+		}
+		b.WriteString(fmt.Sprintf("  %s\n\n", beancountAccount(t.From)))
+	default:
+		posting(t.To, math.Abs(t.Cur))
+		b.WriteString(fmt.Sprintf("  %s\n\n", beancountAccount(t.From)))
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBeancountFormat(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		txn  Txn
+		want string
+	}{
+		{
+			Txn{Date: date, Desc: "STARBUCKS", To: "Expenses:Eating Out", From: "Assets:Bank",
+				Cur: -4.5, CurName: "USD"},
+			"2024-03-01 * \"STARBUCKS\"\n" +
+				"  Expenses:Eating-Out                      4.50 USD\n" +
+				"  Assets:Bank\n\n",
+		},
+		{
+			Txn{Date: date, Desc: "Refund \"A\"", To: "Assets:Bank", From: "Income:Refunds",
+				Cur: 10, CurName: "EUR", Status: statusPending, Note: "two\nlines"},
+			"2024-03-01 ! \"Refund \\\"A\\\"\"\n" +
+				"  note: \"two lines\"\n" +
+				"  Assets:Bank                              10.00 EUR\n" +
+				"  Income:Refunds\n\n",
+		},
+		{
+			Txn{Date: date, Desc: "COSTCO", To: "Expenses:Food", From: "Assets:Bank", Cur: -30,
+				CurName: "USD", Splits: []Posting{{"Expenses:Food", 20}, {"Expenses:Home", 10}}},
+			"2024-03-01 * \"COSTCO\"\n" +
+				"  Expenses:Food                            20.00 USD\n" +
+				"  Expenses:Home                            10.00 USD\n" +
+				"  Assets:Bank\n\n",
+		},
+	}
+	for _, tc := range tests {
+		if got := beancountFormat(tc.txn); got != tc.want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+		}
+	}
+}
+
+func TestBeancountCommodity(t *testing.T) {
+	defer func(c *currencyConf) { curConf = c }(curConf)
+	curConf = &currencyConf{Symbol: "$", Name: "USD"}
+
+	tests := []struct {
+		cur  string
+		want string
+		ok   bool
+	}{
+		{"USD", "USD", true},
+		{"$", "USD", true},
+		{"EUR", "EUR", true},
+		{"€", "", false},
+		{"", "", false},
+		{"usd", "", false},
+	}
+	for _, tc := range tests {
+		got, err := beancountCommodity(tc.cur)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("%q: got %q, %v. Want %q, ok=%v", tc.cur, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBeancountOpeningBalance(t *testing.T) {
+	ob, err := parseOpeningBalance("2024/01/01,100.00", "Assets:Bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob.CurName = "USD"
+	want := "2024-01-01 * \"Opening Balance\"\n" +
+		"  Assets:Bank                              100.00 USD\n" +
+		"  Equity:Opening-Balances\n\n"
+	if got := beancountFormat(ob); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
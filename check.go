@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var checkConfig = flag.Bool("check-config", false, "Validate all the config files in conf"+
+	" dir, report every problem found, and exit.")
+
+// validateConfig loads every config file in the conf dir, and returns all the
+// problems found with them. If a journal is given, categories referred to by the
+// configs are checked against the accounts declared in it.
+func validateConfig(journalPath string) []error {
+	var errs []error
+	file := func(name string) string { return path.Join(*configDir, name) }
+	parseYAML := func(name string, out interface{}) {
+		data, err := ioutil.ReadFile(file(name))
+		if os.IsNotExist(err) {
+			return
+		}
+		if err == nil {
+			err = yaml.Unmarshal(data, out)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Unable to parse %s: %v", file(name), err))
+		}
+	}
+
+	var c configs
+	parseYAML("config.yaml", &c)
+	for acc, ac := range c.Accounts {
+		for k := range ac {
+			if flag.Lookup(k) == nil {
+				errs = append(errs, fmt.Errorf("Unknown flag %q for account %s in config.yaml", k, acc))
+			}
+		}
+	}
+	for name, pf := range c.Profiles {
+		for k := range pf {
+			if flag.Lookup(k) == nil {
+				errs = append(errs, fmt.Errorf("Unknown flag %q for profile %s in config.yaml", k, name))
+			}
+		}
+	}
+
+	for k := range c.JSON {
+		switch k {
+		case "date", "amount", "desc", "currency":
+		default:
+			errs = append(errs, fmt.Errorf("Unknown txn field %q for json in config.yaml", k))
+		}
+	}
+
+	var sc map[string]interface{}
+	parseYAML(*shortcuts, &sc)
+
+	if _, err := os.Stat(file("plaid.yaml")); err == nil {
+		if preq, err := loadPlaidConfig(file("plaid.yaml")); err != nil {
+			errs = append(errs, err)
+		} else if len(preq.Accounts) == 0 {
+			errs = append(errs, fmt.Errorf("Expected accounts in %s", file("plaid.yaml")))
+		}
+	}
+
+	var categories []string
+	rules, rerrs := loadRules(file("rules.yaml"))
+	errs = append(errs, rerrs...)
+	for _, r := range rules {
+		categories = append(categories, r.category, r.debit, r.credit)
+	}
+
+	mccs, err := loadMCCMap(file("mcc_map.yaml"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, cat := range mccs {
+		categories = append(categories, cat)
+	}
+
+	if _, err := loadRates(file("rates.yaml")); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadStopWords(file("stopwords.yaml")); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadCurrencies(file("currencies.yaml")); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(journalPath) == 0 {
+		return errs
+	}
+	data, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		return append(errs, err)
+	}
+	accounts := declaredAccounts(includeAll(path.Dir(journalPath), data))
+	if len(accounts) == 0 {
+		return errs
+	}
+	declared := make(map[string]bool)
+	for _, acc := range accounts {
+		declared[acc] = true
+	}
+	for _, cat := range categories {
+		if len(cat) > 0 && !declared[cat] {
+			errs = append(errs, fmt.Errorf("Category %s isn't declared as an account in %s",
+				cat, journalPath))
+			declared[cat] = true // Report once.
+		}
+	}
+	return errs
+}
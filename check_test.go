@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	defer func(d string) { *configDir = d }(*configDir)
+	journal := "account Expenses:Food\naccount Expenses:Travel\naccount Assets:Bank\n"
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		errs  int
+	}{
+		{"empty", nil, 0},
+		{"valid", map[string]string{
+			"config.yaml":  "accounts:\n  chase:\n    c: $\n",
+			"rules.yaml":   "Expenses:Food:\n  - ^SAFEWAY\n",
+			"mcc_map.yaml": "\"4121\": Expenses:Travel\n",
+		}, 0},
+		{"unknown flags", map[string]string{
+			"config.yaml": "accounts:\n  chase:\n    nope: 1\nprofiles:\n  amex:\n    bad: 2\n",
+		}, 2},
+		{"bad rules", map[string]string{
+			"rules.yaml": "Expenses:Food:\n  - (\n  - ^SAFEWAY\nExpenses:Travel:\n  - \"[\"\n",
+		}, 2},
+		{"undeclared categories", map[string]string{
+			"rules.yaml":   "Expenses:Gifts:\n  - match: ^VENMO\n    credit: Income:Gifts\n",
+			"mcc_map.yaml": "\"5812\": Expenses:Gifts\n",
+		}, 2},
+		{"unparsable", map[string]string{
+			"currencies.yaml": "chase: [\n",
+			"mcc_map.yaml":    "- 5812\n",
+			"config.yaml":     ":\n  - [\n",
+		}, 3},
+		{"invalid currency", map[string]string{
+			"currencies.yaml": "chase:\n  placement: middle\n  name: USD\n",
+		}, 1},
+	}
+	for _, tc := range tests {
+		dir, err := ioutil.TempDir("", "into-ledger")
+		if err != nil {
+			t.Fatal(err)
+		}
+		*configDir = dir
+		for name, data := range tc.files {
+			if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		jpath := path.Join(dir, "journal.ldg")
+		if err := ioutil.WriteFile(jpath, []byte(journal), 0644); err != nil {
+			t.Fatal(err)
+		}
+		errs := validateConfig(jpath)
+		os.RemoveAll(dir)
+		if len(errs) != tc.errs {
+			t.Errorf("%s: got errors %v, want %d", tc.name, errs, tc.errs)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strings"
+
+	"github.com/jbrukh/bayesian"
+)
+
+var (
+	classifier = flag.String("classifier", "bayesian", "Classifier to use for categorizing txns."+
+		" One of: bayesian, centroid.")
+	amountFeature = flag.Bool("amount-feature", false, "Also classify txns by the magnitude"+
+		" of their amount.")
+)
+
+// CategoryScore is the score of a description against a category. Higher is better.
+type CategoryScore struct {
+	Category bayesian.Class
+	Score    float64
+}
+
+// Classifier learns categories from existing txns, and scores new descriptions
+// against them.
+type Classifier interface {
+	// Train learns the given classes from the txns.
+	Train(classes []bayesian.Class, txns []Txn)
+	// Score returns the scores of the description, in the order of the classes
+	// provided to Train.
+	Score(desc string) []CategoryScore
+	// Confidence returns the confidence, between 0 and 1, that the top scoring
+	// category is the right one.
+	Confidence(scores []CategoryScore) float64
+}
+
+func newClassifier(name string) Classifier {
+	switch name {
+	case "bayesian":
+		return &bayesClassifier{}
+	case "centroid":
+		return &centroidClassifier{}
+	}
+	return nil
+}
+
+// amountBand buckets the absolute amount into orders of magnitude.
+func amountBand(amt float64) string {
+	amt = math.Abs(amt)
+	switch {
+	case amt < 10:
+		return "amt_lt10"
+	case amt < 100:
+		return "amt_10_100"
+	case amt < 1000:
+		return "amt_100_1000"
+	}
+	return "amt_gt1000"
+}
+
+// features returns the text the classifier learns from, and scores. This is the
+// description, along with the amount band if -amount-feature is set.
+func features(t Txn) string {
+	if !*amountFeature {
+		return t.Desc
+	}
+	return t.Desc + " " + amountBand(t.Cur)
+}
+
+func terms(desc string) []string {
+	return stops.filter(strings.Split(strings.ToLower(desc), " "))
+}
+
+// bayesClassifier uses a tf-idf naive Bayesian classifier.
+type bayesClassifier struct {
+	classes []bayesian.Class
+	cl      *bayesian.Classifier
+}
+
+func (b *bayesClassifier) Train(classes []bayesian.Class, txns []Txn) {
+	b.classes = classes
+	b.cl = bayesian.NewClassifierTfIdf(classes...)
+	for _, t := range txns {
+		b.cl.Learn(terms(t.Desc), bayesian.Class(t.To))
+	}
+	b.cl.ConvertTermsFreqToTfIdf()
+}
+
+func (b *bayesClassifier) Score(desc string) []CategoryScore {
+	scores, _, _ := b.cl.LogScores(terms(desc))
+	result := make([]CategoryScore, 0, len(scores))
+	for i, score := range scores {
+		result = append(result, CategoryScore{b.classes[i], score})
+	}
+	return result
+}
+
+// Confidence is the softmax of the top score over all the scores. As the scores
+// are log probabilities, this is the posterior probability of the top category.
+func (b *bayesClassifier) Confidence(scores []CategoryScore) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	top := scores[0].Score
+	for _, cs := range scores {
+		top = math.Max(top, cs.Score)
+	}
+	var sum float64
+	for _, cs := range scores {
+		sum += math.Exp(cs.Score - top)
+	}
+	return 1 / sum
+}
+
+// similarityScale scales the cosine similarities of the centroid classifier, when
+// computing its confidence.
+const similarityScale = 10
+
+// centroidClassifier scores a description by the cosine similarity of its tf-idf
+// vector to the centroid of each category's historical descriptions. This fares
+// better than Bayesian for categories with few txns.
+type centroidClassifier struct {
+	classes   []bayesian.Class
+	idf       map[string]float64
+	centroids []map[string]float64
+}
+
+func (c *centroidClassifier) vector(desc string) map[string]float64 {
+	vec := make(map[string]float64)
+	for _, term := range terms(desc) {
+		if len(term) == 0 {
+			continue
+		}
+		if idf, has := c.idf[term]; has {
+			vec[term] += idf
+		}
+	}
+	normalize(vec)
+	return vec
+}
+
+func normalize(vec map[string]float64) {
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for k, v := range vec {
+		vec[k] = v / norm
+	}
+}
+
+func (c *centroidClassifier) Train(classes []bayesian.Class, txns []Txn) {
+	c.classes = classes
+	df := make(map[string]int)
+	for _, t := range txns {
+		seen := make(map[string]bool)
+		for _, term := range terms(t.Desc) {
+			if len(term) > 0 && !seen[term] {
+				seen[term] = true
+				df[term]++
+			}
+		}
+	}
+	c.idf = make(map[string]float64)
+	for term, n := range df {
+		c.idf[term] = 1 + math.Log(float64(len(txns))/float64(n))
+	}
+
+	pos := make(map[bayesian.Class]int)
+	c.centroids = make([]map[string]float64, len(classes))
+	for i, class := range classes {
+		pos[class] = i
+		c.centroids[i] = make(map[string]float64)
+	}
+	for _, t := range txns {
+		centroid := c.centroids[pos[bayesian.Class(t.To)]]
+		for term, v := range c.vector(t.Desc) {
+			centroid[term] += v
+		}
+	}
+	for _, centroid := range c.centroids {
+		normalize(centroid)
+	}
+}
+
+func (c *centroidClassifier) Score(desc string) []CategoryScore {
+	vec := c.vector(desc)
+	result := make([]CategoryScore, 0, len(c.classes))
+	for i, centroid := range c.centroids {
+		var dot float64
+		for term, v := range vec {
+			dot += v * centroid[term]
+		}
+		result = append(result, CategoryScore{c.classes[i], dot})
+	}
+	return result
+}
+
+// Confidence is the softmax of the top similarity over all the similarities. The
+// similarities lie in [0, 1], so they're scaled up first, to let a close match to a
+// single category stand out from the rest.
+func (c *centroidClassifier) Confidence(scores []CategoryScore) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	var top float64
+	for _, cs := range scores {
+		top = math.Max(top, cs.Score)
+	}
+	var sum float64
+	for _, cs := range scores {
+		sum += math.Exp(similarityScale * (cs.Score - top))
+	}
+	return 1 / sum
+}
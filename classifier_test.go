@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jbrukh/bayesian"
+)
+
+func TestCentroidConfidence(t *testing.T) {
+	classes := []bayesian.Class{"Expenses:Food", "Expenses:Travel"}
+	txns := []Txn{
+		{Desc: "STARBUCKS COFFEE", To: "Expenses:Food"},
+		{Desc: "PEETS COFFEE", To: "Expenses:Food"},
+		{Desc: "UBER TRIP", To: "Expenses:Travel"},
+		{Desc: "LYFT RIDE", To: "Expenses:Travel"},
+	}
+	cl := &centroidClassifier{}
+	cl.Train(classes, txns)
+
+	tests := []struct {
+		desc string
+		min  float64
+		max  float64
+	}{
+		{"STARBUCKS COFFEE", 0.9, 1},
+		{"UBER TRIP", 0.9, 1},
+		{"UNKNOWN SHOP", 0.5, 0.5},
+	}
+	for _, tc := range tests {
+		conf := cl.Confidence(cl.Score(tc.desc))
+		if conf < tc.min || conf > tc.max {
+			t.Errorf("%q: got confidence %.2f, want between %.2f and %.2f",
+				tc.desc, conf, tc.min, tc.max)
+		}
+	}
+}
+
+func TestBayesConfidence(t *testing.T) {
+	var b bayesClassifier
+	scores := []CategoryScore{{"a", -1}, {"b", -1}}
+	if conf := b.Confidence(scores); conf != 0.5 {
+		t.Errorf("got confidence %v for equal scores, want 0.5", conf)
+	}
+	if conf := b.Confidence(nil); conf != 0 {
+		t.Errorf("got confidence %v for no scores, want 0", conf)
+	}
+}
+
+func TestRankFewClasses(t *testing.T) {
+	for _, classes := range [][]bayesian.Class{nil, {"Expenses:Food"}} {
+		var txns []Txn
+		for _, class := range classes {
+			txns = append(txns, Txn{Desc: "COFFEE", To: string(class)})
+		}
+		p := parser{cl: &centroidClassifier{}, classes: classes}
+		p.cl.Train(classes, txns)
+
+		txn := Txn{Desc: "COFFEE"}
+		if hits := p.topHits(txn); len(hits) != len(classes) {
+			t.Errorf("%d classes: got hits %v", len(classes), hits)
+		}
+		if _, conf := p.confidence(txn); len(classes) == 0 && conf != 0 {
+			t.Errorf("no classes: got confidence %v, want 0", conf)
+		}
+	}
+}
+
+func TestCentroidScore(t *testing.T) {
+	classes := []bayesian.Class{"Expenses:Food", "Expenses:Travel", "Expenses:Home"}
+	txns := []Txn{
+		{Desc: "STARBUCKS COFFEE", To: "Expenses:Food"},
+		{Desc: "SAFEWAY GROCERY", To: "Expenses:Food"},
+		{Desc: "UBER TRIP", To: "Expenses:Travel"},
+		{Desc: "UNITED AIRLINES", To: "Expenses:Travel"},
+		{Desc: "HOME DEPOT", To: "Expenses:Home"},
+	}
+	cl := newClassifier("centroid")
+	cl.Train(classes, txns)
+
+	tests := []struct {
+		desc string
+		want bayesian.Class
+	}{
+		{"STARBUCKS", "Expenses:Food"},
+		{"safeway grocery store", "Expenses:Food"},
+		{"UBER", "Expenses:Travel"},
+		{"UNITED AIRLINES 016", "Expenses:Travel"},
+		{"THE HOME DEPOT", "Expenses:Home"},
+	}
+	for _, tc := range tests {
+		scores := cl.Score(tc.desc)
+		if len(scores) != len(classes) {
+			t.Fatalf("%q: got %d scores, want %d", tc.desc, len(scores), len(classes))
+		}
+		best := scores[0]
+		for i, s := range scores {
+			if s.Category != classes[i] {
+				t.Errorf("%q: got score %d for %s, want %s", tc.desc, i, s.Category, classes[i])
+			}
+			if s.Score > best.Score {
+				best = s
+			}
+		}
+		if best.Category != tc.want {
+			t.Errorf("%q: got top category %s, want %s", tc.desc, best.Category, tc.want)
+		}
+	}
+}
+
+func TestNewClassifier(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"bayesian", true},
+		{"centroid", true},
+		{"knn", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if cl := newClassifier(tc.name); (cl != nil) != tc.ok {
+			t.Errorf("%q: got classifier %v, want ok=%v", tc.name, cl, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type currencyConf struct {
+	Symbol    string `yaml:"symbol"`    // e.g. $
+	Name      string `yaml:"name"`      // ISO name, e.g. USD
+	Precision *int   `yaml:"precision"` // Number of decimal places. Defaults to 2.
+	Placement string `yaml:"placement"` // prefix or suffix.
+}
+
+// commodity returns the string used for the currency in the journal.
+func (c currencyConf) commodity() string {
+	if len(c.Symbol) > 0 {
+		return c.Symbol
+	}
+	return c.Name
+}
+
+var (
+	baseCurrency = flag.String("base-currency", "", "Annotate amounts in other currencies"+
+		" with their price in this currency, as per rates.yaml in conf dir.")
+
+	rcommodity = regexp.MustCompile(`^commodity\s+(.*)`)
+	riso       = regexp.MustCompile(`^[A-Z]{3}$`)
+
+	// curConf is set if a currency is configured for the account or file being
+	// imported.
+	curConf *currencyConf
+)
+
+// loadCurrency would parse a currencies.yaml file in this format:
+// chase:
+//   symbol: $
+//   name: USD
+//   precision: 2
+//   placement: prefix
+// Activity.csv:
+//   name: EUR
+//   placement: suffix
+// Keys are either account names, as passed via -a, or base names of the CSV
+// files. Account names take precedence. With multiple accounts, they must all have
+// the same currency, as the currency applies to all the txns.
+func loadCurrency(accounts []string, file string) (*currencyConf, error) {
+	table, err := loadCurrencies(path.Join(*configDir, "currencies.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var conf *currencyConf
+	for _, account := range accounts {
+		c, has := table[account]
+		if !has {
+			continue
+		}
+		if conf != nil && !conf.equal(c) {
+			return nil, fmt.Errorf("Accounts in -a have different currencies: %+v and %+v",
+				*conf, c)
+		}
+		conf = &c
+	}
+	if conf != nil {
+		return conf, nil
+	}
+	if c, has := table[path.Base(file)]; has && len(file) > 0 {
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (c currencyConf) equal(o currencyConf) bool {
+	return c.Symbol == o.Symbol && c.Name == o.Name && c.Placement == o.Placement &&
+		*c.Precision == *o.Precision
+}
+
+// loadCurrencies parses and validates all the currencies in the file. A missing
+// file results in no currencies.
+func loadCurrencies(fpath string) (map[string]currencyConf, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+	table := make(map[string]currencyConf)
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("Unable to parse currencies at %s: %v", fpath, err)
+	}
+
+	for key, c := range table {
+		if len(c.commodity()) == 0 {
+			return nil, fmt.Errorf("Expected symbol or name for currency of %s", key)
+		}
+		switch c.Placement {
+		case "":
+			c.Placement = "suffix"
+		case "prefix", "suffix":
+		default:
+			return nil, fmt.Errorf("Invalid placement %q for currency of %s", c.Placement, key)
+		}
+		if c.Precision == nil {
+			two := 2
+			c.Precision = &two
+		}
+		table[key] = c
+	}
+	return table, nil
+}
+
+// checkCommodities warns if the journal declares commodities, but not the
+// configured one.
+func checkCommodities(data []byte, c *currencyConf) {
+	declared := make(map[string]bool)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if m := rcommodity.FindStringSubmatch(s.Text()); len(m) > 1 {
+			declared[strings.TrimSpace(m[1])] = true
+		}
+	}
+	if len(declared) == 0 {
+		return
+	}
+	if declared[c.Symbol] || declared[c.Name] {
+		return
+	}
+	fmt.Printf("WARNING: Currency %q is not declared as a commodity in the journal.\n",
+		c.commodity())
+}
+
+// resolveCurrency picks the currency of a txn. The currency of the txn itself, e.g.
+// from a CSV column or Plaid, is only used if it differs from the currency of the
+// account, which is the one configured in currencies.yaml, or else the -c flag.
+// The txn currency is an ISO name, like USD. So, if the account currency is a
+// symbol, like $, without an ISO name, they can't be told apart, and the account
+// currency is used.
+func resolveCurrency(own string, c *currencyConf, def string) string {
+	if c != nil {
+		if len(own) == 0 || len(c.Name) == 0 || own == c.Name || own == c.Symbol {
+			return c.commodity()
+		}
+		return own
+	}
+	if len(own) == 0 || (len(def) > 0 && !riso.MatchString(def)) {
+		return def
+	}
+	return own
+}
+
+// formatAmount formats the absolute amount of the txn, along with its currency.
+func formatAmount(t Txn) string {
+	amt := math.Abs(t.Cur)
+	if curConf == nil || t.CurName != curConf.commodity() {
+		return fmt.Sprintf("%.2f%s", amt, t.CurName)
+	}
+	if curConf.Placement == "prefix" {
+		return fmt.Sprintf("%s%.*f", t.CurName, *curConf.Precision, amt)
+	}
+	return fmt.Sprintf("%.*f %s", *curConf.Precision, amt, t.CurName)
+}
+
+type rate struct {
+	date  time.Time
+	price float64
+}
+
+// fxRates holds the exchange rates to -base-currency by currency, sorted by date.
+var fxRates map[string][]rate
+
+// loadRates would parse a rates.yaml file in this format:
+// EUR:
+//   2024/03/01: 1.08
+//   2024/03/15: 1.09
+// ...
+// Each rate is the price of a unit of the currency in -base-currency, and applies
+// from its date, until the next rate. A missing file results in no rates.
+func loadRates(fpath string) (map[string][]rate, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+	var raw map[string]map[string]float64
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("Unable to parse rates at %s: %v", fpath, err)
+	}
+	rates := make(map[string][]rate)
+	for cur, byDate := range raw {
+		for d, price := range byDate {
+			date, err := time.Parse(stamp, d)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid date %q for rate of %s: %v", d, cur, err)
+			}
+			if price <= 0 {
+				return nil, fmt.Errorf("Invalid rate %v for %s on %s", price, cur, d)
+			}
+			rates[cur] = append(rates[cur], rate{date, price})
+		}
+		sort.Slice(rates[cur], func(i, j int) bool {
+			return rates[cur][i].date.Before(rates[cur][j].date)
+		})
+	}
+	return rates, nil
+}
+
+// rateFor returns the latest rate of the currency, on or before the date.
+func rateFor(cur string, date time.Time) (float64, bool) {
+	var price float64
+	var found bool
+	for _, r := range fxRates[cur] {
+		if r.date.After(date) {
+			break
+		}
+		price, found = r.price, true
+	}
+	return price, found
+}
+
+// priceOf returns the price of a unit of the txn currency in -base-currency, if
+// the txn is in a different currency, and a rate is known for it.
+func priceOf(t Txn) (float64, bool) {
+	if len(*baseCurrency) == 0 || t.CurName == *baseCurrency {
+		return 0, false
+	}
+	return rateFor(t.CurName, t.Date)
+}
+
+// priceAnnotation returns the ledger price annotation for the amount, like
+// " @ $1.08", if the txn is in a currency other than -base-currency.
+func priceAnnotation(t Txn) string {
+	price, ok := priceOf(t)
+	if !ok {
+		return ""
+	}
+	// Symbols like $ go before the price, and names like USD after it.
+	if len([]rune(*baseCurrency)) == 1 {
+		return fmt.Sprintf(" @ %s%v", *baseCurrency, price)
+	}
+	return fmt.Sprintf(" @ %v %s", price, *baseCurrency)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveCurrency(t *testing.T) {
+	usd := &currencyConf{Symbol: "$", Name: "USD"}
+	dollar := &currencyConf{Symbol: "$"}
+	tests := []struct {
+		own  string
+		conf *currencyConf
+		def  string
+		want string
+	}{
+		{"", nil, "", ""},
+		{"", nil, "$", "$"},
+		{"USD", nil, "", "USD"},
+		{"USD", nil, "$", "$"},
+		{"EUR", nil, "$", "$"},
+		{"USD", nil, "USD", "USD"},
+		{"EUR", nil, "USD", "EUR"},
+		{"", usd, "", "$"},
+		{"USD", usd, "", "$"},
+		{"$", usd, "", "$"},
+		{"EUR", usd, "$", "EUR"},
+		{"EUR", dollar, "", "$"},
+	}
+	for _, tc := range tests {
+		if got := resolveCurrency(tc.own, tc.conf, tc.def); got != tc.want {
+			t.Errorf("resolveCurrency(%q, %+v, %q) = %q, want %q",
+				tc.own, tc.conf, tc.def, got, tc.want)
+		}
+	}
+}
+
+func TestLoadCurrencyAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { *configDir = d }(*configDir)
+	*configDir = dir
+
+	conf := "chase:\n  symbol: $\n  name: USD\n" +
+		"amex:\n  symbol: $\n  name: USD\n" +
+		"hsbc:\n  name: GBP\n" +
+		"Activity.csv:\n  name: EUR\n"
+	if err := ioutil.WriteFile(path.Join(dir, "currencies.yaml"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accounts []string
+		file     string
+		want     string
+		ok       bool
+	}{
+		{[]string{"chase"}, "", "$", true},
+		{[]string{"hsbc"}, "/tmp/Activity.csv", "GBP", true},
+		{[]string{"unknown"}, "/tmp/Activity.csv", "EUR", true},
+		{[]string{"unknown"}, "", "", true},
+		{[]string{"chase", "amex"}, "", "$", true},
+		{[]string{"chase", "hsbc"}, "", "", false},
+	}
+	for _, tc := range tests {
+		c, err := loadCurrency(tc.accounts, tc.file)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: got error %v, want ok=%v", tc.accounts, err, tc.ok)
+			continue
+		}
+		var got string
+		if c != nil {
+			got = c.commodity()
+		}
+		if got != tc.want {
+			t.Errorf("%v, %q: got currency %q, want %q", tc.accounts, tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestLoadCurrencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := path.Join(dir, "currencies.yaml")
+
+	tests := []struct {
+		data string
+		want currencyConf
+		ok   bool
+	}{
+		{"chase:\n  symbol: $\n  name: USD\n", currencyConf{Symbol: "$", Name: "USD", Placement: "suffix"}, true},
+		{"chase:\n  name: JPY\n  precision: 0\n  placement: prefix\n",
+			currencyConf{Name: "JPY", Placement: "prefix"}, true},
+		{"chase:\n  precision: 2\n", currencyConf{}, false},
+		{"chase:\n  name: USD\n  placement: middle\n", currencyConf{}, false},
+		{"chase: [\n", currencyConf{}, false},
+	}
+	for _, tc := range tests {
+		if err := ioutil.WriteFile(fpath, []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		table, err := loadCurrencies(fpath)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: got error %v, want ok=%v", tc.data, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		got := table["chase"]
+		if got.Symbol != tc.want.Symbol || got.Name != tc.want.Name ||
+			got.Placement != tc.want.Placement || got.Precision == nil {
+			t.Errorf("%q: got %+v, want %+v", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	defer func(c *currencyConf) { curConf = c }(curConf)
+	zero, two := 0, 2
+	tests := []struct {
+		conf *currencyConf
+		txn  Txn
+		want string
+	}{
+		{nil, Txn{Cur: -4.5, CurName: "$"}, "4.50$"},
+		{&currencyConf{Symbol: "$", Placement: "prefix", Precision: &two},
+			Txn{Cur: -4.5, CurName: "$"}, "$4.50"},
+		{&currencyConf{Name: "EUR", Placement: "suffix", Precision: &two},
+			Txn{Cur: 4.5, CurName: "EUR"}, "4.50 EUR"},
+		{&currencyConf{Name: "JPY", Placement: "suffix", Precision: &zero},
+			Txn{Cur: -450, CurName: "JPY"}, "450 JPY"},
+		// Txns in another currency aren't formatted as per the config.
+		{&currencyConf{Name: "JPY", Placement: "prefix", Precision: &zero},
+			Txn{Cur: -4.5, CurName: "EUR"}, "4.50EUR"},
+	}
+	for _, tc := range tests {
+		curConf = tc.conf
+		if got := formatAmount(tc.txn); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.txn, got, tc.want)
+		}
+	}
+}
+
+func TestPriceAnnotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := path.Join(dir, "rates.yaml")
+	data := "EUR:\n  2024/03/15: 1.09\n  2024/03/01: 1.08\n"
+	if err := ioutil.WriteFile(fpath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := loadRates(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r map[string][]rate, base, format string) {
+		fxRates, *baseCurrency, *outFormat = r, base, format
+	}(fxRates, *baseCurrency, *outFormat)
+	fxRates = rates
+
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	txn := func(day int, cur string) Txn {
+		return Txn{Date: date(day), Desc: "HOTEL", To: "Expenses:Travel", From: "Assets:Bank",
+			Cur: -50, CurName: cur}
+	}
+	tests := []struct {
+		base   string
+		format string
+		txn    Txn
+		want   string
+	}{
+		{"$", "ledger", txn(10, "EUR"), "\tExpenses:Travel     \t50.00EUR @ $1.08\n"},
+		{"$", "ledger", txn(20, "EUR"), "\tExpenses:Travel     \t50.00EUR @ $1.09\n"},
+		{"USD", "ledger", txn(10, "EUR"), "\tExpenses:Travel     \t50.00EUR @ 1.08 USD\n"},
+		// No rate before the first date, and none needed in the base currency.
+		{"$", "ledger", txn(0, "EUR"), "\tExpenses:Travel     \t50.00EUR\n"},
+		{"USD", "ledger", txn(10, "USD"), "\tExpenses:Travel     \t50.00USD\n"},
+		{"", "ledger", txn(10, "EUR"), "\tExpenses:Travel     \t50.00EUR\n"},
+		{"USD", "beancount", txn(10, "EUR"),
+			"  Expenses:Travel                          50.00 EUR @ 1.08 USD\n"},
+		{"USD", "beancount", txn(10, "USD"),
+			"  Expenses:Travel                          50.00 USD\n"},
+	}
+	for _, tc := range tests {
+		*baseCurrency = tc.base
+		*outFormat = tc.format
+		if got := formatTxn(tc.txn); !strings.Contains(got, tc.want) {
+			t.Errorf("base %q, %s: got:\n%q\nwant it to contain:\n%q", tc.base, tc.format, got, tc.want)
+		}
+	}
+}
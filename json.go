@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var jsonFile = flag.String("json", "", "File path of JSON file containing an array of new"+
+	" transactions. Field names can be mapped via json in config.yaml.")
+
+// jsonFields returns the mapping of txn fields to JSON field names, with defaults
+// for the fields not mapped in config.yaml, like so:
+// json:
+//   date: posted_at
+//   amount: amt
+//   desc: name
+//   currency: iso_currency
+func jsonFields(conf map[string]string) map[string]string {
+	fields := map[string]string{
+		"date":     "date",
+		"amount":   "amount",
+		"desc":     "desc",
+		"currency": "currency",
+	}
+	for k, v := range conf {
+		fields[k] = v
+	}
+	return fields
+}
+
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// parseTransactionsFromJSON parses a JSON array of objects into txns, the same
+// way parseTransactionsFromCSV parses rows.
+func parseTransactionsFromJSON(in []byte, fields map[string]string) []Txn {
+	var rows []map[string]interface{}
+	checkf(json.Unmarshal(in, &rows), "Unable to parse JSON array of txns")
+
+	result := make([]Txn, 0, len(rows))
+	for i, row := range rows {
+		t := Txn{Key: newKey(), Index: len(result)}
+		var ok bool
+		date := jsonString(row[fields["date"]])
+		t.Date, ok = parseDate(date)
+		assertf(ok, "Unable to parse date %q of txn %d", date, i)
+
+		t.Desc, _ = parseDescription(jsonString(row[fields["desc"]]))
+		assertf(len(t.Desc) > 0, "Expected a description for txn %d", i)
+
+		switch v := row[fields["amount"]].(type) {
+		case float64:
+			t.Cur = v
+		default:
+			amt := jsonString(v)
+			t.Cur, ok = parseCurrency(amt)
+			assertf(ok, "Unable to parse amount %q of txn %d", amt, i)
+		}
+		t.CurName = jsonString(row[fields["currency"]])
+		result = append(result, t)
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTransactionsFromJSON(t *testing.T) {
+	defer func(v string) { *dateFormat = v }(*dateFormat)
+	*dateFormat = "2006-01-02"
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		data    string
+		conf    map[string]string
+		desc    string
+		cur     float64
+		curName string
+	}{
+		{"defaults", `[{"date": "2024-03-01", "desc": " COFFEE ", "amount": -4.5, "currency": "USD"}]`,
+			nil, "COFFEE", -4.5, "USD"},
+		{"string amount", `[{"date": "2024-03-01", "desc": "COFFEE", "amount": "($4.50)"}]`,
+			nil, "COFFEE", -4.5, ""},
+		{"mapped fields", `[{"posted_at": "2024-03-01", "name": "RENT \"MARCH\"", "amt": -2000,` +
+			` "iso_currency": "EUR", "desc": "ignored"}]`,
+			map[string]string{"date": "posted_at", "desc": "name", "amount": "amt",
+				"currency": "iso_currency"},
+			"RENT MARCH", -2000, "EUR"},
+	}
+	for _, tc := range tests {
+		txns := parseTransactionsFromJSON([]byte(tc.data), jsonFields(tc.conf))
+		if len(txns) != 1 {
+			t.Fatalf("%s: got %d txns, want 1", tc.name, len(txns))
+		}
+		got := txns[0]
+		if !got.Date.Equal(date) || got.Desc != tc.desc || got.Cur != tc.cur ||
+			got.CurName != tc.curName || len(got.Key) == 0 {
+			t.Errorf("%s: got %+v", tc.name, got)
+		}
+	}
+}
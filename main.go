@@ -3,19 +3,24 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/rand"
+	crand "crypto/rand"
 	"encoding/csv"
 	"encoding/gob"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
 	"sort"
 	"strconv"
 	"strings"
@@ -35,11 +40,13 @@ var (
 	journal    = flag.String("j", "", "Existing journal to learn from.")
 	output     = flag.String("o", "out.ldg", "Journal file to write to.")
 	csvFile    = flag.String("csv", "", "File path of CSV file containing new transactions.")
-	account    = flag.String("a", "", "Name of bank account transactions belong to.")
-	currency   = flag.String("c", "", "Set currency if any.")
+	account    = flag.String("a", "", "Name of bank account transactions belong to. With -p, can be a comma separated list of accounts, or all.")
+	currency   = flag.String("c", "", "Currency of the account. Txns in a different ISO currency keep theirs, unless this is a symbol like $.")
 	ignore     = flag.String("ic", "", "Comma separated list of columns to ignore in CSV.")
 	dateFormat = flag.String("d", "01/02/2006",
 		"Express your date format in numeric form w.r.t. Jan 02, 2006, separated by slashes (/). See: https://golang.org/pkg/time/")
+	dateFormats = flag.String("date-formats", "", "Comma separated list of candidate date"+
+		" formats. The first one to parse a date is used for the whole CSV. Overrides -d.")
 	skip      = flag.Int("s", 0, "Number of header lines in CSV to skip")
 	configDir = flag.String("conf", os.Getenv("HOME")+"/.into-ledger",
 		"Config directory to store various into-ledger configs in.")
@@ -52,12 +59,103 @@ var (
 	usePlaid = flag.Bool("p", false, "Use Plaid to auto-fetch txns."+
 		" You must have set plaid.yaml in conf dir.")
 
+	cpuProfile = flag.String("cpuprofile", "", "Write CPU profile to this file.")
+	memProfile = flag.String("memprofile", "", "Write memory profile to this file.")
+
+	offline = flag.Bool("offline", false, "Guarantee that no network calls are made."+
+		" Fails if combined with any flag requiring network access.")
+
 	dupWithin = flag.Int("within", 24, "Consider txns to be dups, if their dates are not"+
-		" more than N hours apart. Description and amount must also match exactly for"+
-		" a txn to be considered duplicate.")
+		" more than N hours apart. Description (see -dup-similarity) and amount must"+
+		" also match for a txn to be considered duplicate.")
+	dupAmountTol = flag.String("dup-amount-tol", "0", "Amounts within this tolerance are"+
+		" considered equal for dedup. Either absolute, like 0.05, or a percentage, like 1%.")
+	dupSimilarity = flag.Float64("dup-similarity", 1.0, "Minimum similarity of descriptions,"+
+		" between 0 and 1, for txns to be considered dups. 1 requires an exact match.")
+
+	dedupIDs = flag.Bool("dedup-ids", false, "Write stable txn ids from Plaid or OFX as id"+
+		" metadata, and treat incoming txns with ids already in the journal as duplicates.")
+	dedupOutput = flag.Bool("dedup-output", true, "Also deduplicate incoming txns against"+
+		" the txns already present in the output file.")
+
+	failOnDups = flag.Bool("fail-on-duplicates", false, "Exit with failure if any incoming"+
+		" txn is a duplicate.")
+	expectNew = flag.Bool("expect-new", false, "Exit with failure if no incoming txn is new.")
+
+	sign = flag.String("sign", "both", "Import only debits or credits. One of: debit,"+
+		" credit, both.")
+
+	mergeDups = flag.Bool("merge-dups", false, "Report how duplicate txns differ from the"+
+		" matching txns already present in the journal.")
+
+	inbox = flag.Bool("inbox", false, "Write to an inbox file next to the journal, e.g."+
+		" journal.inbox.ldg, unless a different output file is specified.")
+
+	keepDB = flag.Bool("keep-db", false, "Don't remove the boltdb used during the run, so it"+
+		" can be inspected via -dump-db.")
+	dumpDB = flag.String("dump-db", "", "Print the txns stored in this boltdb as JSON, and exit.")
+
+	declareAccounts = flag.Bool("declare-accounts", false, "Append accounts created during"+
+		" review via .new as account declarations to the journal.")
+
+	postHook = flag.String("post-hook", "", "Shell command to run after txns are written."+
+		" The output file is passed as $1, and as INTO_LEDGER_OUTPUT env var.")
+
+	outSort = flag.String("out-sort", "date", "Order of txns written to output. One of:"+
+		" date, account-date, csv-order, amount.")
+
+	uncatOut = flag.String("uncategorized-out", "", "Write txns which weren't categorized"+
+		" during the run to this CSV file.")
+
+	openingBal = flag.String("opening-balance", "", "Emit an opening balance txn before the"+
+		" imported txns, specified as date,amount[,account]. Date is in ledger format"+
+		" (2006/01/02), and account defaults to -a.")
+
+	profile = flag.String("profile", "", "Apply the bundle of flags stored under this"+
+		" profile in config.yaml.")
+
+	numHits = flag.Int("top-hits", 5, "Maximum number of categories suggested for a txn.")
+
+	preview = flag.Int("preview", 0, "Preview the first and last N parsed txns, and ask for"+
+		" confirmation before proceeding.")
+
+	depth = flag.Int("depth", 0, "If set, only suggest categories up to N levels deep,"+
+		" e.g. 2 for Expenses:Food.")
 
 	smallBelow = flag.Float64("below", 0.0, "Use Expenses:Small category for txns below this amount.")
 
+	typeCol = flag.Int("type-col", -1, "Column in CSV which marks a txn as debit or credit."+
+		" If set, the sign of the amount is derived from this column.")
+	debitWords = flag.String("debit", "debit,dr,withdrawal",
+		"Comma separated keywords in type column which mark a debit.")
+	creditWords = flag.String("credit", "credit,cr,deposit",
+		"Comma separated keywords in type column which mark a credit.")
+	seed = flag.Int64("seed", 0, "If non-zero, use this seed to generate txn keys,"+
+		" so a run can be reproduced exactly.")
+	statusCol = flag.Int("status-col", -1, "Column in CSV containing the status of the txn,"+
+		" which is mapped to the cleared or pending marker.")
+	clearedWords = flag.String("status-cleared", "posted,cleared,settled",
+		"Comma separated keywords in status column which mark a txn as cleared.")
+	pendingWords = flag.String("status-pending", "pending,authorized",
+		"Comma separated keywords in status column which mark a txn as pending.")
+	markCleared = flag.Bool("mark-cleared", false, "Mark imported txns as cleared, unless"+
+		" they're known to be pending.")
+	colsMap = flag.String("cols-map", "", "Explicit mapping of CSV columns to txn fields,"+
+		" e.g. date:0,desc:2,amount:5,currency:4. Disables guessing the fields from columns.")
+	currencyCol = flag.Int("currency-col", -1, "Column in CSV containing the currency of the"+
+		" txn.")
+	dateCol = flag.Int("date-col", -1, "Column in CSV containing the date. Other columns"+
+		" which look like dates are ignored.")
+	colsDesc = flag.String("cols-desc", "", "Comma separated list of CSV columns, which are"+
+		" joined with a space to form the description.")
+	amountStrip = flag.String("amount-strip", "$,€£", "Characters to strip from amounts in"+
+		" CSV, like currency symbols and thousands separators.")
+	amountCurCol = flag.Int("amount-with-currency-col", -1, "Column in CSV containing the"+
+		" amount along with its currency, e.g. $45.00, USD 45 or 45.00 EUR.")
+	noteCol = flag.Int("note-col", -1, "Column in CSV containing notes, which are written as"+
+		" comments with the txn.")
+	keepRaw = flag.Bool("keep-raw", false, "Retain the original CSV row with each txn, for debugging.")
+
 	rtxn   = regexp.MustCompile(`(\d{4}/\d{2}/\d{2})[\W]*(\w.*)`)
 	rto    = regexp.MustCompile(`\W*([:\w]+)(.*)`)
 	rfrom  = regexp.MustCompile(`\W*([:\w]+).*`)
@@ -72,12 +170,19 @@ var (
 	short      *keys.Shortcuts
 )
 
+const (
+	statusCleared = "*"
+	statusPending = "!"
+)
+
 type accountFlags struct {
 	flags map[string]string
 }
 
 type configs struct {
 	Accounts map[string]map[string]string // account and the corresponding config.
+	Profiles map[string]map[string]string // profile and the corresponding flags.
+	JSON     map[string]string            // txn field and the corresponding JSON field.
 }
 
 type Txn struct {
@@ -88,10 +193,26 @@ type Txn struct {
 	Cur                float64
 	CurName            string
 	Key                []byte
+	RawRow             []string  // Original CSV row, if -keep-raw is set.
+	MCC                string    // Merchant category code, if -mcc-col is set.
+	Index              int       // Position of the txn in the input.
+	Note               string    // Written as a comment, if -note-col is set.
+	Status             string    // Cleared (*) or pending (!) marker, if any.
+	Account            string    // Source account, if not -a. Set for multiple Plaid accounts.
+	PlaidCategory      string    // Category hint from Plaid, if any.
+	Splits             []Posting // Postings of the category, if split across accounts.
+	ID                 string    // Stable id from the bank, like Plaid's transaction_id or OFX FITID.
+	PendingID          string    // Id of the pending txn, which this txn posts. Set by Plaid.
 	skipClassification bool
 	Done               bool
 }
 
+// Posting is a part of the txn amount, booked against an account.
+type Posting struct {
+	Account string
+	Amount  float64 // Absolute amount.
+}
+
 type byTime []Txn
 
 func (b byTime) Len() int               { return len(b) }
@@ -100,7 +221,8 @@ func (b byTime) Swap(i int, j int)      { b[i], b[j] = b[j], b[i] }
 
 func checkf(err error, format string, args ...interface{}) {
 	if err != nil {
-		log.Printf(format, args)
+		saneMode()
+		log.Printf(format, args...)
 		log.Println()
 		log.Fatalf("%+v", errors.WithStack(err))
 	}
@@ -108,7 +230,8 @@ func checkf(err error, format string, args ...interface{}) {
 
 func assertf(ok bool, format string, args ...interface{}) {
 	if !ok {
-		log.Printf(format, args)
+		saneMode()
+		log.Printf(format, args...)
 		log.Println()
 		log.Fatalf("%+v", errors.Errorf("Should be true, but is false"))
 	}
@@ -133,29 +256,57 @@ type parser struct {
 	data     []byte
 	txns     []Txn
 	classes  []bayesian.Class
-	cl       *bayesian.Classifier
+	cl       Classifier
 	accounts []string
+	dropped  map[string]bool // keys of txns marked as duplicates during review.
+	rules    []rule
+	fullDesc bool // show the full description during review.
+	memory   map[string]payeeMemory
+	outTxns  []Txn                // txns already in the output file, only used for dedup.
+	ids      map[string]bool      // ids of txns already in the journal and output file.
+	totals   map[string][]Posting // category postings of txns written to db, by key.
+	examples map[string][]Txn     // txns learnt from, by class.
 }
 
 func (p *parser) parseTransactions() {
-	out, err := exec.Command("ledger", "-f", *journal, "csv").Output()
-	checkf(err, "Unable to convert journal to csv. Possibly an issue with your ledger installation.")
+	txns, err := ledgerTxns(*journal)
+	checkf(err, "Unable to parse journal: %v", *journal)
+	for _, t := range txns {
+		p.txns = append(p.txns, t)
+		assignForAccount(t.To)
+	}
+}
+
+// ledgerTxns uses ledger to convert the journal to csv, and parses the txns.
+func ledgerTxns(fpath string) ([]Txn, error) {
+	out, err := exec.Command("ledger", "-f", fpath, "csv").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to convert journal to csv."+
+			" Possibly an issue with your ledger installation")
+	}
 	r := csv.NewReader(newConverter(bytes.NewReader(out)))
+	var txns []Txn
 	var t Txn
 	for {
 		cols, err := r.Read()
 		if err == io.EOF {
 			break
 		}
-		checkf(err, "Unable to read a csv line.")
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read a csv line")
+		}
 
 		t = Txn{}
 		t.Date, err = time.Parse(stamp, cols[0])
-		checkf(err, "Unable to parse time: %v", cols[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to parse time: %v", cols[0])
+		}
 		t.Desc = strings.Trim(cols[2], " \n\t")
 
 		t.To = cols[3]
-		assertf(len(t.To) > 0, "Expected TO, found empty.")
+		if len(t.To) == 0 {
+			return nil, errors.New("Expected TO, found empty")
+		}
 		if strings.HasPrefix(t.To, "Assets:Reimbursements:") {
 			// pass
 		} else if strings.HasPrefix(t.To, "Assets:") {
@@ -170,57 +321,79 @@ func (p *parser) parseTransactions() {
 		}
 		t.CurName = cols[4]
 		t.Cur, err = strconv.ParseFloat(cols[5], 64)
-		checkf(err, "Unable to parse amount.")
-		p.txns = append(p.txns, t)
-
-		assignForAccount(t.To)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to parse amount")
+		}
+		txns = append(txns, t)
 	}
+	return txns, nil
 }
 
 func (p *parser) parseAccounts() {
-	s := bufio.NewScanner(bytes.NewReader(p.data))
-	var acc string
+	p.accounts = declaredAccounts(p.data)
+	for _, acc := range p.accounts {
+		assignForAccount(acc)
+	}
+}
+
+// declaredAccounts returns the accounts declared in the journal.
+func declaredAccounts(data []byte) []string {
+	var accounts []string
+	s := bufio.NewScanner(bytes.NewReader(data))
 	for s.Scan() {
 		m := racc.FindStringSubmatch(s.Text())
-		if len(m) < 2 {
-			continue
-		}
-		acc = m[1]
-		if len(acc) == 0 {
+		if len(m) < 2 || len(m[1]) == 0 {
 			continue
 		}
-		p.accounts = append(p.accounts, acc)
-		assignForAccount(acc)
+		accounts = append(accounts, m[1])
+	}
+	return accounts
+}
+
+// truncateAccount returns the account truncated to the given number of levels.
+// Zero or negative depth means no truncation.
+func truncateAccount(acc string, depth int) string {
+	if depth <= 0 {
+		return acc
 	}
+	tree := strings.SplitN(acc, ":", depth+1)
+	if len(tree) <= depth {
+		return acc
+	}
+	return strings.Join(tree[:depth], ":")
 }
 
 func (p *parser) generateClasses() {
+	p.train(p.txns)
+	for _, class := range p.classes {
+		fmt.Printf("[Class] %s\n", class)
+	}
+}
+
+// train builds the classes and the classifier from the given txns.
+func (p *parser) train(txns []Txn) {
 	p.classes = make([]bayesian.Class, 0, 10)
+	p.examples = make(map[string][]Txn)
 	tomap := make(map[string]bool)
-	for _, t := range p.txns {
+	var learn []Txn
+	for _, t := range txns {
 		if t.skipClassification {
 			continue
 		}
+		t.To = truncateAccount(t.To, *depth)
+		p.examples[t.To] = append(p.examples[t.To], t)
+		t.Desc = features(t)
 		tomap[t.To] = true
-	}
-	for class := range tomap {
-		fmt.Printf("[Class] %s\n", class)
+		learn = append(learn, t)
 	}
 	for to := range tomap {
 		p.classes = append(p.classes, bayesian.Class(to))
 	}
 	assertf(len(p.classes) > 1, "Expected some categories. Found none.")
 
-	p.cl = bayesian.NewClassifierTfIdf(p.classes...)
-	assertf(p.cl != nil, "Expected a valid classifier. Found nil.")
-	for _, t := range p.txns {
-		if _, has := tomap[t.To]; !has {
-			continue
-		}
-		desc := strings.ToLower(t.Desc)
-		p.cl.Learn(strings.Split(desc, " "), bayesian.Class(t.To))
-	}
-	p.cl.ConvertTermsFreqToTfIdf()
+	p.cl = newClassifier(*classifier)
+	assertf(p.cl != nil, "Expected a valid classifier. Found nil for: %v", *classifier)
+	p.cl.Train(p.classes, learn)
 }
 
 type pair struct {
@@ -240,28 +413,43 @@ func (b byScore) Swap(i int, j int) {
 	b[i], b[j] = b[j], b[i]
 }
 
-func (p *parser) topHits(in string) []bayesian.Class {
-	in = strings.ToLower(in)
-	terms := strings.Split(in, " ")
-	scores, _, _ := p.cl.LogScores(terms)
+// rank returns the classes sorted by their score for the given description,
+// along with the standard deviation of the scores.
+func (p *parser) rank(t Txn) ([]pair, float64) {
+	scores := p.cl.Score(features(t))
 	pairs := make([]pair, 0, len(scores))
 
 	var mean, stddev float64
-	for pos, score := range scores {
-		pairs = append(pairs, pair{score, pos})
-		mean += score
+	for pos, cs := range scores {
+		pairs = append(pairs, pair{cs.Score, pos})
+		mean += cs.Score
+	}
+	if len(scores) < 2 {
+		return pairs, 0
 	}
 	mean /= float64(len(scores))
-	for _, score := range scores {
-		stddev += math.Pow(score-mean, 2)
+	for _, cs := range scores {
+		stddev += math.Pow(cs.Score-mean, 2)
 	}
 	stddev /= float64(len(scores) - 1)
 	stddev = math.Sqrt(stddev)
 
 	sort.Sort(byScore(pairs))
-	result := make([]bayesian.Class, 0, 5)
+	return pairs, stddev
+}
+
+func (p *parser) topHits(t Txn) []bayesian.Class {
+	pairs, stddev := p.rank(t)
+	maxResults := *numHits
+	if maxResults > len(pairs) {
+		maxResults = len(pairs)
+	}
+	result := make([]bayesian.Class, 0, maxResults)
+	if len(pairs) == 0 {
+		return result
+	}
 	last := pairs[0].score
-	for i := 0; i < 5; i++ {
+	for i := 0; i < maxResults; i++ {
 		pr := pairs[i]
 		if *debug {
 			fmt.Printf("i=%d s=%f Class=%v\n", i, pr.score, p.classes[pr.pos])
@@ -275,6 +463,39 @@ func (p *parser) topHits(in string) []bayesian.Class {
 	return result
 }
 
+// nearestExample returns the txn in the class, whose description shares the most
+// terms with desc, preferring recent txns. This is shown to explain a suggestion.
+func (p *parser) nearestExample(class bayesian.Class, desc string) (Txn, bool) {
+	want := make(map[string]bool)
+	for _, term := range terms(desc) {
+		if len(term) > 0 {
+			want[term] = true
+		}
+	}
+	var best Txn
+	var bestScore float64
+	for _, t := range p.examples[string(class)] {
+		var common, total int
+		seen := make(map[string]bool)
+		for _, term := range terms(t.Desc) {
+			if len(term) == 0 || seen[term] {
+				continue
+			}
+			seen[term] = true
+			total++
+			if want[term] {
+				common++
+			}
+		}
+		// Jaccard similarity of the terms.
+		score := float64(common) / float64(len(want)+total-common)
+		if score > bestScore || (score == bestScore && score > 0 && t.Date.After(best.Date)) {
+			best, bestScore = t, score
+		}
+	}
+	return best, bestScore > 0
+}
+
 func includeAll(dir string, data []byte) []byte {
 	final := make([]byte, len(data))
 	copy(final, data)
@@ -294,19 +515,119 @@ func includeAll(dir string, data []byte) []byte {
 	return final
 }
 
-func parseDate(col string) (time.Time, bool) {
-	tm, err := time.Parse(*dateFormat, col)
+// lockedFormat is the first of the -date-formats, which parsed a date.
+var lockedFormat string
+
+func parseDateWith(layout, col string) (time.Time, bool) {
+	tm, err := time.Parse(layout, col)
 	if err == nil {
+		if hasTwoDigitYear(layout) {
+			tm = normalizeCentury(tm, time.Now())
+		}
 		return tm, true
 	}
 	return time.Time{}, false
 }
 
+// parseDate parses the date using -d. If -date-formats are provided, the first
+// format which parses a date is used for all the subsequent dates.
+func parseDate(col string) (time.Time, bool) {
+	if len(*dateFormats) == 0 {
+		return parseDateWith(*dateFormat, col)
+	}
+	if len(lockedFormat) > 0 {
+		return parseDateWith(lockedFormat, col)
+	}
+	for _, layout := range strings.Split(*dateFormats, ",") {
+		layout = strings.TrimSpace(layout)
+		if tm, ok := parseDateWith(layout, col); ok {
+			lockedFormat = layout
+			fmt.Printf("Using date format: %s\n", layout)
+			return tm, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func hasTwoDigitYear(layout string) bool {
+	return strings.Contains(strings.Replace(layout, "2006", "", -1), "06")
+}
+
+// normalizeCentury moves a date parsed from a two digit year into the century
+// which places it within 50 years of now.
+func normalizeCentury(tm, now time.Time) time.Time {
+	for tm.Year() > now.Year()+50 {
+		tm = tm.AddDate(-100, 0, 0)
+	}
+	for tm.Year() < now.Year()-50 {
+		tm = tm.AddDate(100, 0, 0)
+	}
+	return tm
+}
+
+// isPlausibleDate returns false for dates in the future, or too far in the past,
+// which typically indicate a wrong date format.
+func isPlausibleDate(tm, now time.Time) bool {
+	return !tm.After(now.AddDate(0, 0, 7)) && tm.After(now.AddDate(-20, 0, 0))
+}
+
 func parseCurrency(col string) (float64, bool) {
 	f, err := strconv.ParseFloat(col, 64)
+	if err == nil {
+		return f, true
+	}
+	// Accounting style negatives, like (45.00) or ($45.00).
+	col = strings.TrimSpace(col)
+	var negate bool
+	if strings.HasPrefix(col, "(") && strings.HasSuffix(col, ")") {
+		col = col[1 : len(col)-1]
+		if strings.ContainsAny(col, "()+-") {
+			return 0, false
+		}
+		negate = true
+	}
+
+	// Strip currency symbols and thousands separators, like in $1,234.56 or -$12.00.
+	col = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(*amountStrip, r) {
+			return -1
+		}
+		return r
+	}, col)
+	f, err = strconv.ParseFloat(col, 64)
+	if negate {
+		f = -f
+	}
 	return f, err == nil
 }
 
+var ramountCur = regexp.MustCompile(
+	`^([-+]?)\s*([^\d\s.,+-]*)\s*([-+]?\d[\d,]*(?:\.\d+)?)\s*([^\d\s.,+-]*)$`)
+
+// parseAmountWithCurrency parses an amount with the currency either before or
+// after it, like $45.00, -$45.00, USD 45 or 45.00 EUR.
+func parseAmountWithCurrency(col string) (float64, string, bool) {
+	m := ramountCur.FindStringSubmatch(strings.TrimSpace(col))
+	if m == nil {
+		return 0, "", false
+	}
+	sign, prefix, num, suffix := m[1], m[2], m[3], m[4]
+	if len(prefix) > 0 && len(suffix) > 0 {
+		return 0, "", false
+	}
+	if len(sign) > 0 && (num[0] == '-' || num[0] == '+') {
+		return 0, "", false
+	}
+	f, err := strconv.ParseFloat(strings.Replace(num, ",", "", -1), 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if sign == "-" {
+		f = -f
+	}
+	return f, prefix + suffix, true
+}
+
 func parseDescription(col string) (string, bool) {
 	return strings.Map(func(r rune) rune {
 		if r == '"' {
@@ -316,6 +637,109 @@ func parseDescription(col string) (string, bool) {
 	}, col), true
 }
 
+func parseKeywords(list string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.Split(list, ",") {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if len(w) > 0 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// applyTypeSign flips the sign of the txn amount, based on the value of the type
+// column. Unknown values leave the amount as is.
+func applyTypeSign(t *Txn, kind string, debits, credits map[string]bool) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	switch {
+	case debits[kind]:
+		t.Cur = -math.Abs(t.Cur)
+	case credits[kind]:
+		t.Cur = math.Abs(t.Cur)
+	default:
+		fmt.Printf("WARNING: Unknown value %q in type column %d for txn: %v. Keeping amount as is.\n",
+			kind, *typeCol, t.Desc)
+	}
+}
+
+var (
+	rng      *rand.Rand
+	usedKeys = make(map[string]bool)
+)
+
+// newKey returns a unique key for a txn, so we can uniquely identify and persist
+// them as we modify their category. Keys are reproducible if -seed is set.
+func newKey() []byte {
+	key := make([]byte, 16)
+	for {
+		if *seed != 0 {
+			if rng == nil {
+				rng = rand.New(rand.NewSource(*seed))
+			}
+			rng.Read(key)
+		} else {
+			_, err := crand.Read(key)
+			checkf(err, "Unable to generate a random key")
+		}
+		if !usedKeys[string(key)] {
+			usedKeys[string(key)] = true
+			return key
+		}
+	}
+}
+
+// parseColsMap parses the -cols-map flag. Date, desc and amount must be mapped.
+func parseColsMap(spec string) (map[string]int, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	mapping := make(map[string]int)
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Expected field:column, found: %q", kv)
+		}
+		switch parts[0] {
+		case "date", "desc", "amount", "currency":
+		default:
+			return nil, fmt.Errorf("Unknown field: %q", parts[0])
+		}
+		pos, err := strconv.Atoi(parts[1])
+		if err != nil || pos < 0 {
+			return nil, fmt.Errorf("Invalid column %q for field %s", parts[1], parts[0])
+		}
+		mapping[parts[0]] = pos
+	}
+	for _, field := range []string{"date", "desc", "amount"} {
+		if _, has := mapping[field]; !has {
+			return nil, fmt.Errorf("Field %s is unmapped", field)
+		}
+	}
+	return mapping, nil
+}
+
+// applyColsMap sets the txn fields from the columns mapped to them.
+func applyColsMap(t *Txn, cols []string, mapping map[string]int) {
+	for field, pos := range mapping {
+		if pos >= len(cols) {
+			fatalf("Column %d mapped to %s is missing in CSV line: %v",
+				pos, field, strings.Join(cols, ", "))
+		}
+		col := cols[pos]
+		switch field {
+		case "date":
+			t.Date, _ = parseDate(col)
+		case "desc":
+			t.Desc, _ = parseDescription(col)
+		case "amount":
+			t.Cur, _ = parseCurrency(col)
+		case "currency":
+			t.CurName = strings.TrimSpace(col)
+		}
+	}
+}
+
 func parseTransactionsFromCSV(in []byte) []Txn {
 	ignored := make(map[int]bool)
 	if len(*ignore) > 0 {
@@ -325,16 +749,32 @@ func parseTransactionsFromCSV(in []byte) []Txn {
 			ignored[pos] = true
 		}
 	}
+	debits := parseKeywords(*debitWords)
+	credits := parseKeywords(*creditWords)
+	cleared := parseKeywords(*clearedWords)
+	pending := parseKeywords(*pendingWords)
+	mapping, err := parseColsMap(*colsMap)
+	checkf(err, "Invalid -cols-map: %v", *colsMap)
+	var descCols []int
+	isDescCol := make(map[int]bool)
+	if len(*colsDesc) > 0 {
+		for _, i := range strings.Split(*colsDesc, ",") {
+			pos, err := strconv.Atoi(strings.TrimSpace(i))
+			checkf(err, "Unable to convert to integer: %v", i)
+			descCols = append(descCols, pos)
+			isDescCol[pos] = true
+		}
+	}
 
+	// Excel on Windows prefixes the CSV with a UTF-8 BOM, which would otherwise
+	// end up in the first column of the first row.
+	in = bytes.TrimPrefix(in, []byte("\xef\xbb\xbf"))
 	result := make([]Txn, 0, 100)
 	r := csv.NewReader(bytes.NewReader(in))
 	var t Txn
 	var skipped int
 	for {
-		t = Txn{Key: make([]byte, 16)}
-		// Have a unique key for each transaction in CSV, so we can unique identify and
-		// persist them as we modify their category.
-		_, err := rand.Read(t.Key)
+		t = Txn{Key: newKey(), Index: len(result)}
 		cols, err := r.Read()
 		if err == io.EOF {
 			break
@@ -346,13 +786,60 @@ func parseTransactionsFromCSV(in []byte) []Txn {
 		}
 
 		var picked []string
+		var kind string
+		var dates int
 		for i, col := range cols {
 			if ignored[i] {
 				continue
 			}
 			picked = append(picked, col)
-			if date, ok := parseDate(col); ok {
+			if i == *typeCol {
+				kind = col
+				continue
+			}
+			if i == *statusCol {
+				// Unknown values leave the txn uncleared.
+				switch status := strings.ToLower(strings.TrimSpace(col)); {
+				case cleared[status]:
+					t.Status = statusCleared
+				case pending[status]:
+					t.Status = statusPending
+				}
+				continue
+			}
+			if i == *amountCurCol {
+				if f, cur, ok := parseAmountWithCurrency(col); ok {
+					t.Cur, t.CurName = f, cur
+				}
+				continue
+			}
+			if i == *currencyCol {
+				t.CurName = strings.TrimSpace(col)
+				continue
+			}
+			if i == *noteCol {
+				t.Note = strings.TrimSpace(col)
+				continue
+			}
+			if i == *mccCol {
+				t.MCC = strings.TrimSpace(col)
+				continue
+			}
+			if mapping != nil || isDescCol[i] {
+				continue
+			}
+			if i == *dateCol {
+				date, ok := parseDate(col)
+				assertf(ok, "Unable to parse date %q in -date-col %d", col, i)
 				t.Date = date
+				continue
+			}
+			if date, ok := parseDate(col); ok {
+				// With -date-col set, other date columns are ignored.
+				if *dateCol < 0 {
+					t.Date = date
+					dates++
+				}
 
 			} else if f, ok := parseCurrency(col); ok {
 				t.Cur = f
@@ -362,6 +849,34 @@ func parseTransactionsFromCSV(in []byte) []Txn {
 			}
 		}
 
+		if dates > 1 {
+			fmt.Printf("WARNING: Found %d date columns in row: %v. Using the last one."+
+				" Set -date-col to pick one.\n", dates, strings.Join(cols, ", "))
+		}
+		if mapping != nil {
+			applyColsMap(&t, cols, mapping)
+		}
+		if len(descCols) > 0 {
+			var parts []string
+			for _, pos := range descCols {
+				if pos < len(cols) && len(strings.TrimSpace(cols[pos])) > 0 {
+					parts = append(parts, strings.TrimSpace(cols[pos]))
+				}
+			}
+			t.Desc, _ = parseDescription(strings.Join(parts, " "))
+		}
+		if *keepRaw {
+			t.RawRow = cols
+		}
+		if *typeCol >= 0 {
+			applyTypeSign(&t, kind, debits, credits)
+		}
+
+		if !t.Date.IsZero() && !isPlausibleDate(t.Date, time.Now()) {
+			fmt.Printf("WARNING: Implausible date %s for txn: %v. Please check the date format.\n",
+				t.Date.Format(stamp), t.Desc)
+		}
+
 		if len(t.Desc) != 0 && !t.Date.IsZero() && t.Cur != 0.0 {
 			y, m, d := t.Date.Year(), t.Date.Month(), t.Date.Day()
 			t.Date = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
@@ -373,7 +888,10 @@ func parseTransactionsFromCSV(in []byte) []Txn {
 			fmt.Printf("Parsed Date     : %v\n", t.Date)
 			fmt.Printf("Parsed Desc     : %v\n", t.Desc)
 			fmt.Printf("Parsed Currency : %v\n", t.Cur)
-			log.Fatalln("Please ensure that the above CSV contains ALL the 3 required fields.")
+			if len(lockedFormat) > 0 {
+				fmt.Printf("Date Format     : %v\n", lockedFormat)
+			}
+			fatalf("Please ensure that the above CSV contains ALL the 3 required fields.")
 		}
 	}
 	return result
@@ -395,6 +913,12 @@ func setDefaultMappings(ks *keys.Shortcuts) {
 	ks.BestEffortAssign('q', ".quit", "default")
 	ks.BestEffortAssign('a', ".show all", "default")
 	ks.BestEffortAssign('s', ".skip", "default")
+	ks.BestEffortAssign('d', ".dup", "default")
+	ks.BestEffortAssign('f', ".from", "default")
+	ks.BestEffortAssign('v', ".full", "default")
+	ks.BestEffortAssign('e', ".edit", "default")
+	ks.BestEffortAssign('p', ".split", "default")
+	ks.BestEffortAssign('n', ".new", "default")
 }
 
 type kv struct {
@@ -416,15 +940,151 @@ func (b byVal) Swap(i int, j int) {
 	b[i], b[j] = b[j], b[i]
 }
 
-func singleCharMode() {
-	// disable input buffering
-	exec.Command("stty", "-F", "/dev/tty", "cbreak", "min", "1").Run()
-	// do not display entered characters on the screen
-	exec.Command("stty", "-F", "/dev/tty", "-echo").Run()
+// readKey reads a single key press from stdin. It returns false if stdin is
+// exhausted or closed, which should be treated as a request to quit.
+func readKey() (byte, bool) {
+	r := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(r)
+		if n == 1 {
+			return r[0], true
+		}
+		if err != nil {
+			return 0, false
+		}
+	}
+}
+
+// parseSplit parses a line of the form: account amount.
+func parseSplit(line string) (Posting, error) {
+	var ps Posting
+	line = strings.TrimSpace(line)
+	idx := strings.LastIndexAny(line, " \t")
+	if idx < 0 {
+		return ps, fmt.Errorf("Expected an account and an amount. Got: %q", line)
+	}
+	amt, ok := parseCurrency(strings.TrimSpace(line[idx+1:]))
+	if !ok || amt <= 0 {
+		return ps, fmt.Errorf("Invalid amount in: %q", line)
+	}
+	ps.Account = strings.TrimSpace(line[:idx])
+	ps.Amount = amt
+	return ps, nil
+}
+
+// readAccount asks for the full path of an account, like Expenses:Medical:Dental.
+func readAccount() (string, bool) {
+	fmt.Println()
+	fmt.Printf("New account: ")
+	line, ok := readLine()
+	if !ok {
+		return "", false
+	}
+	acc := strings.TrimSpace(line)
+	// Ledger ends the account name at a tab, or two spaces.
+	if len(acc) == 0 || strings.Contains(acc, "\t") || strings.Contains(acc, "  ") ||
+		strings.Contains(acc, "::") || strings.HasSuffix(acc, ":") {
+		fmt.Printf("Invalid account %q. Press any key to continue.", acc)
+		readKey()
+		return "", false
+	}
+	return acc, true
+}
+
+// addAccount assigns shortcuts to a new account, so it's offered for the rest of
+// the run. With -declare-accounts, it's also declared in the journal.
+func (p *parser) addAccount(acc string) {
+	assignForAccount(acc)
+	for _, a := range p.accounts {
+		if a == acc {
+			return
+		}
+	}
+	p.accounts = append(p.accounts, acc)
+	if !*declareAccounts {
+		return
+	}
+	f, err := os.OpenFile(*journal, os.O_APPEND|os.O_WRONLY, 0644)
+	checkf(err, "Unable to open journal: %v", *journal)
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "\naccount %s\n", acc)
+	checkf(err, "Unable to declare account %s in journal: %v", acc, *journal)
+}
+
+// scaleSplits scales the postings proportionally, so they add up to amt. The
+// rounding error is absorbed by the last posting.
+func scaleSplits(splits []Posting, amt float64) []Posting {
+	if len(splits) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, ps := range splits {
+		sum += ps.Amount
+	}
+	scaled := make([]Posting, 0, len(splits))
+	rest := amt
+	for i, ps := range splits {
+		if i == len(splits)-1 {
+			ps.Amount = math.Round(rest*100) / 100
+		} else {
+			ps.Amount = math.Round(ps.Amount*amt/sum*100) / 100
+			rest -= ps.Amount
+		}
+		scaled = append(scaled, ps)
+	}
+	return scaled
+}
+
+// readSplits asks for the postings to split the txn amount into, one per line,
+// until an empty line. The postings must add up to the txn amount.
+func readSplits(t Txn) ([]Posting, bool) {
+	fmt.Println()
+	fmt.Printf("Split %.2f into postings, one per line as: account amount. End with an empty line.\n",
+		math.Abs(t.Cur))
+	var splits []Posting
+	var sum float64
+	for {
+		line, ok := readLine()
+		if !ok {
+			return nil, false
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			break
+		}
+		ps, err := parseSplit(line)
+		if err != nil {
+			fmt.Printf("\t%v\n", err)
+			continue
+		}
+		splits = append(splits, ps)
+		sum += ps.Amount
+		fmt.Printf("\tRemaining: %.2f\n", math.Abs(t.Cur)-sum)
+	}
+	if len(splits) < 2 || math.Abs(sum-math.Abs(t.Cur)) > 0.005 {
+		fmt.Printf("Expected at least 2 postings adding up to %.2f. Got %d adding up to %.2f."+
+			" Press any key to continue.", math.Abs(t.Cur), len(splits), sum)
+		readKey()
+		return nil, false
+	}
+	return splits, true
 }
 
-func saneMode() {
-	exec.Command("stty", "-F", "/dev/tty", "sane").Run()
+// readLine reads a line from stdin, with the terminal in sane mode, so the input
+// is echoed and can be edited.
+func readLine() (string, bool) {
+	saneMode()
+	defer singleCharMode()
+	var line []byte
+	for {
+		key, ok := readKey()
+		if !ok {
+			return "", false
+		}
+		if key == '\n' {
+			return string(line), true
+		}
+		line = append(line, key)
+	}
 }
 
 func getCategory(t Txn) (prefix, cat string) {
@@ -437,6 +1097,26 @@ func getCategory(t Txn) (prefix, cat string) {
 	return
 }
 
+// sourceAccount returns the bank account the txn was imported from.
+func sourceAccount(t Txn) string {
+	if len(t.Account) > 0 {
+		return t.Account
+	}
+	return *account
+}
+
+// getSource returns the side of the txn opposite to its category, which is
+// typically the account the txns are being imported for.
+func getSource(t Txn) (prefix, src string) {
+	prefix = "[FROM]"
+	src = t.From
+	if t.Cur > 0 {
+		prefix = "[TO]"
+		src = t.To
+	}
+	return
+}
+
 func printCategory(t Txn) {
 	prefix, cat := getCategory(t)
 	if len(cat) == 0 {
@@ -465,7 +1145,7 @@ func printSummary(t Txn, idx, total int) {
 		// A bit of a hack, but will do.
 		color.New(color.BgBlue, color.FgWhite).Printf(" [DUPLICATE] ")
 	} else {
-		log.Fatalf("Unhandled case for total: %v", total)
+		fatalf("Unhandled case for total: %v", total)
 	}
 
 	color.New(color.BgYellow, color.FgBlack).Printf(" %10s ", t.Date.Format(stamp))
@@ -496,8 +1176,29 @@ func (p *parser) writeToDB(t Txn) {
 		return b.Put(t.Key, val.Bytes())
 
 	}); err != nil {
-		log.Fatalf("Write to db failed with error: %v", err)
+		fatalf("Write to db failed with error: %v", err)
+	}
+	delete(p.dropped, string(t.Key))
+	p.trackTotal(t)
+}
+
+func (p *parser) deleteFromDB(key []byte) {
+	if err := p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete(key)
+	}); err != nil {
+		fatalf("Delete from db failed with error: %v", err)
+	}
+	delete(p.totals, string(key))
+}
+
+// markDuplicate excludes the txn from the final output.
+func (p *parser) markDuplicate(t *Txn) {
+	p.deleteFromDB(t.Key)
+	t.Done = false
+	if p.dropped == nil {
+		p.dropped = make(map[string]bool)
 	}
+	p.dropped[string(t.Key)] = true
 }
 
 func (p *parser) iterateDB() []Txn {
@@ -509,23 +1210,62 @@ func (p *parser) iterateDB() []Txn {
 			var t Txn
 			dec := gob.NewDecoder(bytes.NewBuffer(v))
 			if err := dec.Decode(&t); err != nil {
-				log.Fatalf("Unable to parse txn from value of length: %v. Error: %v", len(v), err)
+				fatalf("Unable to parse txn from value of length: %v. Error: %v", len(v), err)
 			}
 			txns = append(txns, t)
 		}
 		return nil
 	}); err != nil {
-		log.Fatalf("Iterate over db failed with error: %v", err)
+		fatalf("Iterate over db failed with error: %v", err)
 	}
 	return txns
 }
 
-func (p *parser) printAndGetResult(ks keys.Shortcuts, t *Txn) float64 {
-	label := "default"
+// dumpTxns writes all the txns stored in the boltdb at the given path as JSON.
+func dumpTxns(w io.Writer, fpath string) {
+	db, err := bolt.Open(fpath, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	checkf(err, "Unable to open boltdb at %v", fpath)
+	defer db.Close()
+
+	p := parser{db: db}
+	for _, t := range p.iterateDB() {
+		data, err := json.MarshalIndent(t, "", "  ")
+		checkf(err, "Unable to marshal txn: %+v", t)
+		fmt.Fprintf(w, "%s\n", data)
+	}
+}
+
+// selection is the category picked so far for a txn. It is carried over when
+// switching to the full list of shortcuts via .show all.
+type selection struct {
+	category []string
+	label    string
+	source   bool
+}
+
+// resume keeps the selection, if its label can be continued from via the
+// shortcuts. Otherwise, it starts over from the default label.
+func (sel *selection) resume(hasLabel func(string) bool) {
+	if len(sel.label) == 0 || !hasLabel(sel.label) {
+		sel.label = "default"
+		sel.category = sel.category[:0]
+	}
+}
+
+func (p *parser) printAndGetResult(ks keys.Shortcuts, t *Txn, sel *selection) float64 {
+	sel.resume(ks.HasLabel)
+	label, category, source := sel.label, sel.category, sel.source
+	defer func() {
+		sel.label, sel.category, sel.source = label, category, source
+	}()
 
 	var repeat bool
-	var category []string
 LOOP:
+	if source {
+		fmt.Println()
+		color.New(color.BgWhite, color.FgBlack).Printf("Selecting source account")
+		fmt.Println()
+	}
 	if len(category) > 0 {
 		fmt.Println()
 		color.New(color.BgWhite, color.FgBlack).Printf("Selected [%s]", strings.Join(category, ":")) // descLength used in Printf.
@@ -533,9 +1273,11 @@ LOOP:
 	}
 
 	ks.Print(label, false)
-	r := make([]byte, 1)
-	os.Stdin.Read(r)
-	ch := rune(r[0])
+	key, ok := readKey()
+	if !ok {
+		return 999999.0
+	}
+	ch := rune(key)
 	if ch == rune(10) && len(t.To) > 0 && len(t.From) > 0 {
 		p.writeToDB(*t)
 		t.Done = true
@@ -551,18 +1293,59 @@ LOOP:
 			return -1.0
 		case ".skip":
 			return 1.1
+		case ".dup":
+			p.markDuplicate(t)
+			return 1.1
 		case ".quit":
 			return 999999.0
 		case ".show all":
 			return math.MaxFloat32
+		case ".full":
+			p.fullDesc = !p.fullDesc
+			return 0
+		case ".split":
+			splits, ok := readSplits(*t)
+			if !ok {
+				return 0
+			}
+			t.Splits = splits
+			if t.Cur > 0 {
+				t.From = splits[0].Account
+			} else {
+				t.To = splits[0].Account
+			}
+			p.writeToDB(*t)
+			t.Done = true
+			return 1.0
+		case ".edit":
+			fmt.Println()
+			fmt.Printf("Description [%s]: ", t.Desc)
+			if desc, ok := readLine(); ok && len(strings.TrimSpace(desc)) > 0 {
+				t.Desc = strings.TrimSpace(desc)
+			}
+			// Show the txn again, with suggestions for the new description.
+			return 0
+		case ".new":
+			acc, ok := readAccount()
+			if !ok {
+				return 0
+			}
+			setAccount(t, acc, source)
+			p.addAccount(acc)
+			p.writeToDB(*t)
+			t.Done = true
+			return 1.0
+		case ".from":
+			// Switch to picking the source account from all the accounts.
+			source = true
+			category = category[:0]
+			label = "default"
+			ks = *short
+			goto LOOP
 		}
 
 		category = append(category, opt)
-		if t.Cur > 0 {
-			t.From = strings.Join(category, ":")
-		} else {
-			t.To = strings.Join(category, ":")
-		}
+		setAccount(t, strings.Join(category, ":"), source)
 		label = opt
 		if ks.HasLabel(label) {
 			repeat = true
@@ -572,14 +1355,32 @@ LOOP:
 	return 0
 }
 
-func (p *parser) categorizeTxn(t *Txn, idx, total int) float64 {
-	clear()
-	printSummary(*t, idx, total)
+// setAccount sets the category of the txn to acc, or its source account if
+// source is set.
+func setAccount(t *Txn, acc string, source bool) {
+	if (t.Cur > 0) != source {
+		t.From = acc
+	} else {
+		t.To = acc
+	}
+}
+
+// printContext prints the details of the txn which don't fit in its summary.
+func (p *parser) printContext(t *Txn) {
 	fmt.Println()
-	if len(t.Desc) > descLength {
+	if p.fullDesc && len(t.Desc) > descLength {
 		color.New(color.BgWhite, color.FgBlack).Printf("%6s %s ", "[DESC]", t.Desc) // descLength used in Printf.
 		fmt.Println()
 	}
+	if len(t.Note) > 0 {
+		fmt.Printf("%6s %s\n", "[NOTE]", t.Note)
+	}
+	if len(t.PlaidCategory) > 0 {
+		fmt.Printf("%6s %s\n", "[PLAID]", t.PlaidCategory)
+	}
+	if *debug && len(t.RawRow) > 0 {
+		fmt.Printf("%6s %s\n", "[RAW]", strings.Join(t.RawRow, ", "))
+	}
 	{
 		prefix, cat := getCategory(*t)
 		if len(cat) > catLength {
@@ -587,27 +1388,57 @@ func (p *parser) categorizeTxn(t *Txn, idx, total int) float64 {
 			fmt.Println()
 		}
 	}
+	if prefix, src := getSource(*t); src != sourceAccount(*t) {
+		color.New(color.BgCyan, color.FgBlack).Printf("%6s %s", prefix, src)
+		fmt.Println()
+	}
 	fmt.Println()
+}
 
-	hits := p.topHits(t.Desc)
+func (p *parser) categorizeTxn(t *Txn, idx, total int) float64 {
+	clear()
+	p.printTotals()
+	printSummary(*t, idx, total)
+	p.printContext(t)
+
+	hits := p.topHits(*t)
 	var ks keys.Shortcuts
 	setDefaultMappings(&ks)
+	var explained bool
 	for _, hit := range hits {
 		ks.AutoAssign(string(hit), "default")
+		if ex, ok := p.nearestExample(hit, t.Desc); ok {
+			fmt.Printf("%6s %s <- %s on %s\n", "[WHY]", hit, ex.Desc, ex.Date.Format(stamp))
+			explained = true
+		}
 	}
-	res := p.printAndGetResult(ks, t)
+	if explained {
+		fmt.Println()
+	}
+	var sel selection
+	res := p.printAndGetResult(ks, t, &sel)
 	if res != math.MaxFloat32 {
 		return res
 	}
 
 	clear()
+	p.printTotals()
 	printSummary(*t, idx, total)
-	return p.printAndGetResult(*short, t)
+	p.printContext(t)
+	suggested := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		suggested = append(suggested, string(hit))
+	}
+	fmt.Printf("%6s %s\n", "[HITS]", strings.Join(suggested, ", "))
+	return p.printAndGetResult(*short, t, &sel)
 }
 
 func (p *parser) classifyTxn(t *Txn) {
 	if !t.Done {
-		hits := p.topHits(t.Desc)
+		hits := p.topHits(*t)
+		if len(hits) == 0 {
+			return
+		}
 		if t.Cur < 0 {
 			t.To = string(hits[0])
 		} else {
@@ -629,13 +1460,13 @@ func (p *parser) showAndCategorizeTxns(rtxns []Txn) {
 		fmt.Println()
 
 		fmt.Printf("Found %d transactions. Review (Y/n/q)? ", len(txns))
-		b := make([]byte, 1)
-		os.Stdin.Read(b)
-		if b[0] == 'n' || b[0] == 'q' {
+		key, ok := readKey()
+		if !ok || key == 'n' || key == 'q' {
 			return
 		}
 
-		applyToSimilarTxns := func(from int) int {
+		var steps []step // steps taken so far, which can be undone via .back.
+		applyToSimilarTxns := func(from int, st *step) int {
 			t := txns[from]
 			src := lettersOnly.ReplaceAllString(t.Desc, "")
 			for i := from + 1; i < len(txns); i++ {
@@ -647,11 +1478,13 @@ func (p *parser) showAndCategorizeTxns(rtxns []Txn) {
 					return i
 				}
 
+				st.record(i, *dst)
 				if t.Cur > 0 {
 					dst.From = t.From
 				} else {
 					dst.To = t.To
 				}
+				dst.Splits = scaleSplits(t.Splits, math.Abs(dst.Cur))
 				dst.Done = true
 			}
 			return len(txns)
@@ -659,9 +1492,23 @@ func (p *parser) showAndCategorizeTxns(rtxns []Txn) {
 
 		for i := 0; i < len(txns) && i >= 0; {
 			t := &txns[i]
+			st := step{pos: i}
+			st.record(i, *t)
 			res := p.categorizeTxn(t, i, len(txns))
+			if res == -1.0 && len(steps) > 0 {
+				// Undo the last step, along with any txns categorized as similar.
+				*t = st.prev[0]
+				last := steps[len(steps)-1]
+				steps = steps[:len(steps)-1]
+				p.undo(txns, last)
+				i = last.pos
+				continue
+			}
+			if res == 1.0 || res == 1.1 {
+				steps = append(steps, st)
+			}
 			if res == 1.0 {
-				upto := applyToSimilarTxns(i)
+				upto := applyToSimilarTxns(i, &steps[len(steps)-1])
 				if upto == i+1 {
 					// Did not find anything.
 					i += int(res)
@@ -675,9 +1522,8 @@ func (p *parser) showAndCategorizeTxns(rtxns []Txn) {
 				}
 				fmt.Println()
 				fmt.Println("The above txns were similar to the last categorized txns, " +
-					"and were categorized accordingly. Can be changed by skipping back and forth.")
-				r := make([]byte, 1)
-				os.Stdin.Read(r)
+					"and were categorized accordingly. Press back on the next txn to undo.")
+				readKey()
 				i = upto
 			} else {
 				i += int(res)
@@ -686,14 +1532,79 @@ func (p *parser) showAndCategorizeTxns(rtxns []Txn) {
 	}
 }
 
+// step is a single step of the review, which can be undone via .back.
+type step struct {
+	pos  int   // position of the txn reviewed in this step.
+	idx  []int // positions of the txns modified in this step.
+	prev []Txn // state of those txns, before this step.
+}
+
+func (st *step) record(idx int, t Txn) {
+	st.idx = append(st.idx, idx)
+	st.prev = append(st.prev, t)
+}
+
+// undo restores the txns modified in the step, in memory and in the db.
+func (p *parser) undo(txns []Txn, st step) {
+	for i, idx := range st.idx {
+		t := st.prev[i]
+		if t.Done {
+			p.writeToDB(t)
+		} else {
+			p.deleteFromDB(t.Key)
+			delete(p.dropped, string(t.Key))
+		}
+		txns[idx] = t
+	}
+}
+
 func ledgerFormat(t Txn) string {
 	var b bytes.Buffer
-	b.WriteString(fmt.Sprintf("%s\t%s\n", t.Date.Format(stamp), t.Desc))
-	b.WriteString(fmt.Sprintf("\t%-20s\t%.2f%s\n", t.To, math.Abs(t.Cur), t.CurName))
+	if len(t.Status) > 0 {
+		b.WriteString(fmt.Sprintf("%s %s\t%s\n", t.Date.Format(stamp), t.Status, t.Desc))
+	} else {
+		b.WriteString(fmt.Sprintf("%s\t%s\n", t.Date.Format(stamp), t.Desc))
+	}
+	if t.Status == statusPending {
+		b.WriteString("\t; :pending:\n")
+	}
+	if len(t.Note) > 0 {
+		b.WriteString(fmt.Sprintf("\t; %s\n", strings.Replace(t.Note, "\n", " ", -1)))
+	}
+	if keepID(t) {
+		b.WriteString(fmt.Sprintf("\t; id: %s\n", t.ID))
+	}
+	if len(t.Splits) > 0 {
+		writeSplits(&b, t)
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("\t%-20s\t%s%s\n", t.To, formatAmount(t), priceAnnotation(t)))
 	b.WriteString(fmt.Sprintf("\t%s\n\n", t.From))
 	return b.String()
 }
 
+// writeSplits writes a posting per split. For debits, the splits are debited, and
+// From balances them. For credits, To is credited, and the splits balance it.
+func writeSplits(b *bytes.Buffer, t Txn) {
+	split := func(ps Posting, sign string) {
+		st := Txn{Date: t.Date, Cur: ps.Amount, CurName: t.CurName}
+		b.WriteString(fmt.Sprintf("\t%-20s\t%s%s%s\n", ps.Account, sign, formatAmount(st),
+			priceAnnotation(st)))
+	}
+	if t.Cur > 0 {
+		b.WriteString(fmt.Sprintf("\t%-20s\t%s%s\n", t.To, formatAmount(t), priceAnnotation(t)))
+		for _, ps := range t.Splits {
+			split(ps, "-")
+		}
+		b.WriteString("\n")
+		return
+	}
+	for _, ps := range t.Splits {
+		split(ps, "")
+	}
+	b.WriteString(fmt.Sprintf("\t%s\n\n", t.From))
+}
+
 func sanitize(a string) string {
 	return strings.Map(func(r rune) rune {
 		if r >= 'a' && r <= 'z' {
@@ -719,7 +1630,6 @@ func sanitize(a string) string {
 		default:
 			return -1
 		}
-		return -1
 	}, a)
 }
 
@@ -744,92 +1654,61 @@ func (p *parser) categorizeBelow(txns []Txn) []Txn {
 	return unmatched
 }
 
-// This function would use a rules.yaml file in this format:
-// Expenses:Travel:
-//   - regexp-for-description
-//   - ^LYFT\ +\*RIDE
-// Expenses:Food:
-//   - ^STARBUCKS
-// ...
-// If this file is present, txns would be auto-categorized, if their description
-// mathces the regular expressions provided.
-func (p *parser) categorizeByRules(txns []Txn) []Txn {
-	fpath := path.Join(*configDir, "rules.yaml")
-	data, err := ioutil.ReadFile(fpath)
-	if err != nil {
-		return txns
-	}
-
-	rules := make(map[string][]string)
-	checkf(yaml.Unmarshal(data, &rules), "Unable to parse auto.yaml confit at %s", fpath)
-
-	matchesCategory := func(t Txn) string {
-		for category, patterns := range rules {
-			for _, pattern := range patterns {
-				match, err := regexp.Match(pattern, []byte(t.Desc))
-				checkf(err, "Unable to parse regexp")
-				if match {
-					return category
-				}
-			}
-		}
-		return ""
-	}
-
-	unmatched := txns[:0]
-	var count int
-	for _, t := range txns {
-		if cat := matchesCategory(t); len(cat) > 0 {
-			if t.Cur > 0 {
-				t.From = cat
-			} else {
-				t.To = cat
-			}
-			count++
-			printSummary(t, count, count)
-			p.writeToDB(t)
-		} else {
-			unmatched = append(unmatched, t)
-		}
-	}
-	fmt.Printf("\t%d txns have been categorized based on rules.\n\n", len(txns)-len(unmatched))
-	return unmatched
-}
-
 func (p *parser) removeDuplicates(txns []Txn) []Txn {
 	if len(txns) == 0 {
 		return txns
 	}
 
-	sort.Sort(byTime(p.txns))
+	existing := p.txns
+	if len(p.outTxns) > 0 {
+		existing = append(append([]Txn(nil), p.txns...), p.outTxns...)
+	}
+	sort.Sort(byTime(existing))
 	sort.Sort(byTime(txns))
 
-	prev := p.txns
-	first := txns[0].Date.Add(-24 * time.Hour)
-	for i, t := range p.txns {
+	// Only compare against txns which could fall within the allowed window of the
+	// earliest incoming txn. Keep a day of margin, as journal dates have no time.
+	allowed := time.Duration(*dupWithin) * time.Hour
+	prev := existing
+	first := txns[0].Date.Add(-allowed - 24*time.Hour)
+	for i, t := range existing {
 		if t.Date.After(first) {
-			prev = p.txns[i:]
+			prev = existing[i:]
 			break
 		}
 	}
 
-	allowed := time.Duration(*dupWithin) * time.Hour
 	within := func(a, b time.Time) bool {
 		dur := a.Sub(b)
 		return math.Abs(float64(dur)) <= float64(allowed)
 	}
+	tol, err := parseTolerance(*dupAmountTol)
+	checkf(err, "Invalid -dup-amount-tol: %v", *dupAmountTol)
 
 	final := txns[:0]
 	for _, t := range txns {
+		if *dedupIDs && len(t.ID) > 0 && p.ids[t.ID] {
+			printSummary(t, 0, 0)
+			continue
+		}
 		var found bool
 		tdesc := sanitize(t.Desc)
 		for _, pr := range prev {
 			if pr.Date.After(t.Date.Add(allowed)) {
 				break
 			}
+			if !within(pr.Date, t.Date) || !tol.equal(pr.Cur, t.Cur) {
+				continue
+			}
 			pdesc := sanitize(pr.Desc)
-			if tdesc == pdesc && within(pr.Date, t.Date) && math.Abs(pr.Cur) == math.Abs(t.Cur) {
+			if tdesc == pdesc || similarity(tdesc, pdesc) >= *dupSimilarity {
 				printSummary(t, 0, 0)
+				if tdesc != pdesc {
+					fmt.Printf("\tSimilar to: %s\n", pr.Desc)
+				}
+				if *mergeDups {
+					printDiff(pr, t)
+				}
 				found = true
 				break
 			}
@@ -842,6 +1721,357 @@ func (p *parser) removeDuplicates(txns []Txn) []Txn {
 	return final
 }
 
+// tolerance is the allowed difference between amounts, either absolute or as a
+// percentage of the larger amount.
+type tolerance struct {
+	amount  float64
+	percent bool
+}
+
+func parseTolerance(s string) (tolerance, error) {
+	var tol tolerance
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		tol.percent = true
+		s = strings.TrimSuffix(s, "%")
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return tol, err
+	}
+	if f < 0 {
+		return tol, fmt.Errorf("Expected a non-negative tolerance. Got: %v", f)
+	}
+	tol.amount = f
+	return tol, nil
+}
+
+// equal returns true if the absolute amounts are within the tolerance.
+func (tol tolerance) equal(a, b float64) bool {
+	a, b = math.Abs(a), math.Abs(b)
+	if a == b {
+		return true
+	}
+	allowed := tol.amount
+	if tol.percent {
+		allowed = tol.amount / 100 * math.Max(a, b)
+	}
+	// Allow for floating point error, e.g. 10.05 - 10.00 > 0.05.
+	return math.Abs(a-b) <= allowed+1e-9
+}
+
+// rid matches id metadata, written by both ledgerFormat and beancountFormat.
+var rid = regexp.MustCompile(`(?m)^\s+(?:;\s*)?id:\s*"?([^"\s]+)`)
+
+// keepID returns true if the id of the txn should be written with it. Pending
+// txns always keep their id, so they can be found once they post.
+func keepID(t Txn) bool {
+	return len(t.ID) > 0 && (*dedupIDs || t.Status == statusPending)
+}
+
+// readIDs adds the ids in the id metadata of the txns in the journal to ids.
+func readIDs(ids map[string]bool, data []byte) {
+	for _, m := range rid.FindAllSubmatch(data, -1) {
+		ids[string(m[1])] = true
+	}
+}
+
+// similarity returns 1 minus the Levenshtein distance between the strings, as a
+// ratio of the length of the longer string.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// diffTxn returns the fields which differ between the existing txn in the
+// journal and the incoming duplicate txn.
+func diffTxn(existing, incoming Txn) []string {
+	var lines []string
+	diff := func(field, a, b string) {
+		if a != b {
+			lines = append(lines, fmt.Sprintf("%-10s journal: %-40s incoming: %s", field, a, b))
+		}
+	}
+	diff("Date", existing.Date.Format(stamp), incoming.Date.Format(stamp))
+	diff("Desc", existing.Desc, incoming.Desc)
+	diff("Amount", fmt.Sprintf("%.2f", math.Abs(existing.Cur)), fmt.Sprintf("%.2f", math.Abs(incoming.Cur)))
+	if len(incoming.CurName) > 0 {
+		diff("Currency", existing.CurName, incoming.CurName)
+	}
+	return lines
+}
+
+func printDiff(existing, incoming Txn) {
+	for _, line := range diffTxn(existing, incoming) {
+		fmt.Printf("\t%s\n", line)
+	}
+}
+
+// checkDups returns an error if the number of new txns, out of the incoming
+// ones, fails -fail-on-duplicates or -expect-new.
+func checkDups(incoming, fresh int) error {
+	if *failOnDups && fresh < incoming {
+		return fmt.Errorf("-fail-on-duplicates: %d of %d incoming txns are duplicates.",
+			incoming-fresh, incoming)
+	}
+	if *expectNew && fresh == 0 {
+		return fmt.Errorf("-expect-new: None of the %d incoming txns are new.", incoming)
+	}
+	return nil
+}
+
+// clearUnmarked marks txns without a status as cleared. Pending txns keep
+// their status, until they post.
+func clearUnmarked(txns []Txn) {
+	for i := range txns {
+		if len(txns[i].Status) == 0 {
+			txns[i].Status = statusCleared
+		}
+	}
+}
+
+// filterBySign retains only debits (negative amounts) or credits (positive
+// amounts), if asked.
+func filterBySign(txns []Txn, mode string) []Txn {
+	if mode == "both" {
+		return txns
+	}
+	final := txns[:0]
+	for _, t := range txns {
+		if (mode == "debit" && t.Cur < 0) || (mode == "credit" && t.Cur > 0) {
+			final = append(final, t)
+		}
+	}
+	fmt.Printf("\t%d txns filtered out, retaining only %ss.\n\n", len(txns)-len(final), mode)
+	return final
+}
+
+const openingAccount = "Equity:Opening Balances"
+
+// applyProfile sets the flags in the named profile, and returns them. No
+// profile name sets nothing.
+func applyProfile(c configs, name string) (map[string]string, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	pf, has := c.Profiles[name]
+	if !has {
+		return nil, fmt.Errorf("Unknown profile: %s", name)
+	}
+	fmt.Printf("Using flags from profile %s: %+v\n", name, pf)
+	for k, v := range pf {
+		if err := flag.Set(k, v); err != nil {
+			return nil, fmt.Errorf("Unable to set flag %q from profile %s: %v", k, name, err)
+		}
+	}
+	return pf, nil
+}
+
+// applyAccountFlags sets the flags in the config of the account, unless the
+// profile already set them.
+func applyAccountFlags(ac, pf map[string]string) {
+	for k, v := range ac {
+		if _, ok := pf[k]; !ok {
+			flag.Set(k, v)
+		}
+	}
+}
+
+// configFlags returns the flags in the config of the accounts. With multiple
+// accounts, the flags apply to all of them. So, they must not conflict.
+func configFlags(configs map[string]map[string]string, accounts []string) (map[string]string, error) {
+	flags := make(map[string]string)
+	from := make(map[string]string)
+	for _, account := range accounts {
+		for k, v := range configs[account] {
+			if prev, has := flags[k]; has && prev != v {
+				return nil, fmt.Errorf("Flag %s is set to %q for account %s, but %q for account %s",
+					k, prev, from[k], v, account)
+			}
+			flags[k] = v
+			from[k] = account
+		}
+	}
+	return flags, nil
+}
+
+// parseOpeningBalance parses the -opening-balance spec into a txn, which moves the
+// amount between the account and the opening balances equity account.
+func parseOpeningBalance(spec, account string) (Txn, error) {
+	var t Txn
+	parts := strings.Split(spec, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return t, fmt.Errorf("Expected date,amount[,account]. Got: %q", spec)
+	}
+	date, err := time.Parse(stamp, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return t, fmt.Errorf("Invalid date %q: %v", parts[0], err)
+	}
+	amt, ok := parseCurrency(strings.TrimSpace(parts[1]))
+	if !ok || amt == 0 {
+		return t, fmt.Errorf("Invalid amount %q", parts[1])
+	}
+	if len(parts) == 3 {
+		account = strings.TrimSpace(parts[2])
+	}
+	if len(account) == 0 {
+		return t, fmt.Errorf("Expected an account for the opening balance")
+	}
+
+	t = Txn{Date: date, Desc: "Opening Balance", Cur: amt, Status: statusCleared}
+	// ledgerFormat writes the absolute amount against To, and leaves From to
+	// balance it. So, a negative balance flows the other way.
+	if amt > 0 {
+		t.To, t.From = account, openingAccount
+	} else {
+		t.To, t.From = openingAccount, account
+	}
+	return t, nil
+}
+
+// runPostHook runs the hook command via shell, and exits with failure if the
+// hook fails.
+func runPostHook(hook, out string) {
+	fmt.Printf("Running post hook: %s\n", hook)
+	res, err := postHookCmd(hook, out).CombinedOutput()
+	fmt.Printf("%s", res)
+	checkf(err, "Post hook failed: %v", hook)
+}
+
+// postHookCmd returns the shell command for the hook, with the output file
+// passed as $1, and as INTO_LEDGER_OUTPUT env var.
+func postHookCmd(hook, out string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", hook, "into-ledger", out)
+	cmd.Env = append(os.Environ(), "INTO_LEDGER_OUTPUT="+out)
+	return cmd
+}
+
+func sortOutput(txns []Txn, order string) {
+	switch order {
+	case "date":
+		sort.Stable(byTime(txns))
+	case "account-date":
+		sort.SliceStable(txns, func(i, j int) bool {
+			if txns[i].To != txns[j].To {
+				return txns[i].To < txns[j].To
+			}
+			return txns[i].Date.Before(txns[j].Date)
+		})
+	case "csv-order":
+		sort.SliceStable(txns, func(i, j int) bool { return txns[i].Index < txns[j].Index })
+	case "amount":
+		sort.SliceStable(txns, func(i, j int) bool {
+			return math.Abs(txns[i].Cur) > math.Abs(txns[j].Cur)
+		})
+	default:
+		fatalf("Invalid value for out-sort flag: %q", order)
+	}
+}
+
+// writeUncategorized writes the imported txns, which didn't make it to the db,
+// and weren't dropped as duplicates, to a CSV file for triage.
+func (p *parser) writeUncategorized(fname string, imported, final []Txn) {
+	done := make(map[string]bool)
+	for _, t := range final {
+		done[string(t.Key)] = true
+	}
+
+	f, err := os.Create(fname)
+	checkf(err, "Unable to create file: %v", fname)
+	w := csv.NewWriter(f)
+	checkf(w.Write([]string{"date", "desc", "amount", "source"}), "Unable to write to: %v", fname)
+	var count int
+	for _, t := range imported {
+		if done[string(t.Key)] || p.dropped[string(t.Key)] {
+			continue
+		}
+		_, src := getSource(t)
+		rec := []string{t.Date.Format(stamp), t.Desc, strconv.FormatFloat(t.Cur, 'f', 2, 64), src}
+		checkf(w.Write(rec), "Unable to write to: %v", fname)
+		count++
+	}
+	w.Flush()
+	checkf(w.Error(), "Unable to write to: %v", fname)
+	checkf(f.Close(), "Unable to close: %v", fname)
+	fmt.Printf("%d uncategorized txns written to file: %s\n", count, fname)
+}
+
+// previewTxns prints the first and last n txns, and returns whether the user
+// wants to proceed with them.
+func previewTxns(txns []Txn, n int) bool {
+	fmt.Printf("Preview of parsed txns (account: %s):\n", *account)
+	for i, t := range txns {
+		if i == n && len(txns) > 2*n {
+			fmt.Printf("\t... %d more txns ...\n", len(txns)-2*n)
+		}
+		if inPreview(i, len(txns), n) {
+			printSummary(t, i+1, len(txns))
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Proceed with %d transactions (Y/n)? ", len(txns))
+	key, ok := readKey()
+	fmt.Println()
+	return ok && key != 'n' && key != 'q'
+}
+
+// inPreview returns true if the txn at index i is among the first or last n of
+// total txns.
+func inPreview(i, total, n int) bool {
+	return i < n || i >= total-n
+}
+
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func samePath(a, b string) bool {
+	pa, erra := filepath.Abs(a)
+	pb, errb := filepath.Abs(b)
+	return erra == nil && errb == nil && pa == pb
+}
+
+// inboxPath returns journal.inbox.ldg for journal.ldg.
+func inboxPath(journal string) string {
+	ext := filepath.Ext(journal)
+	return strings.TrimSuffix(journal, ext) + ".inbox" + ext
+}
+
 var errc = color.New(color.BgRed, color.FgWhite).PrintfFunc()
 
 func oerr(msg string) {
@@ -852,33 +2082,166 @@ func oerr(msg string) {
 	fmt.Println()
 }
 
+// checkOffline fails if any network requiring flag is set along with -offline.
+func checkOffline() {
+	if !*offline {
+		return
+	}
+	if *usePlaid || len(*plaidHist) > 0 {
+		fatalf("Plaid flags (-p, -phist) require network access, and can't be used with -offline.")
+	}
+}
+
+// startProfiles starts the CPU profile, if asked. The returned func stops it, and
+// writes the memory profile, if asked.
+func startProfiles() func() {
+	if len(*cpuProfile) > 0 {
+		f, err := os.Create(*cpuProfile)
+		checkf(err, "Unable to create cpu profile: %v", *cpuProfile)
+		checkf(pprof.StartCPUProfile(f), "Unable to start cpu profile")
+	}
+	return func() {
+		if len(*cpuProfile) > 0 {
+			pprof.StopCPUProfile()
+			fmt.Printf("CPU profile written to: %s\n", *cpuProfile)
+		}
+		if len(*memProfile) > 0 {
+			f, err := os.Create(*memProfile)
+			checkf(err, "Unable to create memory profile: %v", *memProfile)
+			runtime.GC()
+			checkf(pprof.WriteHeapProfile(f), "Unable to write memory profile")
+			checkf(f.Close(), "Unable to close memory profile")
+			fmt.Printf("Memory profile written to: %s\n", *memProfile)
+		}
+	}
+}
+
 func main() {
 	flag.Parse()
+	defer startProfiles()()
+	checkOffline()
 
 	if *plaidHist != "" {
 		fmt.Printf("Balance history error: %v\n", BalanceHistory(*account))
 		return
 	}
 
-	defer saneMode()
-	singleCharMode()
+	if !*autoCommit {
+		defer saneMode()
+		singleCharMode()
+		restoreOnSignal()
+	}
 
 	checkf(os.MkdirAll(*configDir, 0755), "Unable to create directory: %v", *configDir)
-	if len(*account) == 0 {
-		oerr("Please specify the account transactions are coming from")
+	if len(*dumpDB) > 0 {
+		dumpTxns(os.Stdout, *dumpDB)
+		return
+	}
+	if *checkConfig {
+		errs := validateConfig(*journal)
+		for _, err := range errs {
+			errc("\tERROR: " + err.Error() + " ")
+			fmt.Println()
+		}
+		fmt.Printf("Found %d problems with config in %s.\n", len(errs), *configDir)
 		return
 	}
+	rules, errs := loadRules(path.Join(*configDir, "rules.yaml"))
+	for _, err := range errs {
+		errc("\tERROR: " + err.Error() + " ")
+		fmt.Println()
+	}
+	if *checkRules {
+		fmt.Printf("Found %d valid and %d invalid rules.\n", len(rules), len(errs))
+		return
+	}
+	assertf(len(errs) == 0, "Please fix the invalid rules above.")
+	sw, err := loadStopWords(path.Join(*configDir, "stopwords.yaml"))
+	checkf(err, "Unable to load stop words")
+	stops = sw
+	if len(*baseCurrency) > 0 {
+		fxRates, err = loadRates(path.Join(*configDir, "rates.yaml"))
+		checkf(err, "Unable to load rates")
+	}
 
+	var c configs
 	configPath := path.Join(*configDir, "config.yaml")
 	data, err := ioutil.ReadFile(configPath)
 	if err == nil {
-		var c configs
 		checkf(yaml.Unmarshal(data, &c), "Unable to unmarshal yaml config at %v", configPath)
-		if ac, has := c.Accounts[*account]; has {
-			fmt.Printf("Using flags from config: %+v\n", ac)
-			for k, v := range ac {
-				flag.Set(k, v)
-			}
+	}
+	// A profile can also pick the account, so apply it first. Its flags then take
+	// precedence over the ones for the account.
+	pf, err := applyProfile(c, *profile)
+	if err != nil {
+		oerr(err.Error())
+		return
+	}
+	if len(*account) == 0 {
+		oerr("Please specify the account transactions are coming from")
+		return
+	}
+	accounts := []string{*account}
+	if *usePlaid {
+		accounts, err = plaidAccounts(*account)
+		checkf(err, "Unable to find Plaid accounts: %v", *account)
+	} else if strings.Contains(*account, ",") {
+		oerr("Multiple accounts in -a can only be imported via -p")
+		return
+	}
+	ac, err := configFlags(c.Accounts, accounts)
+	if err != nil {
+		oerr(err.Error())
+		return
+	}
+	if len(ac) > 0 {
+		fmt.Printf("Using flags from config: %+v\n", ac)
+		applyAccountFlags(ac, pf)
+	}
+	// The opening balance defaults to -a, which must then be a single account.
+	defAccount := *account
+	if len(accounts) > 1 {
+		defAccount = ""
+	}
+	checkOffline()
+	sortOutput(nil, *outSort) // Fail early on an invalid order.
+	switch *outFormat {
+	case "ledger", "beancount":
+	default:
+		oerr("Invalid value for -format: " + *outFormat)
+		return
+	}
+	switch *sign {
+	case "debit", "credit", "both":
+	default:
+		oerr("Invalid value for -sign: " + *sign)
+		return
+	}
+	if _, err := parseTolerance(*dupAmountTol); err != nil {
+		oerr("Invalid -dup-amount-tol: " + err.Error())
+		return
+	}
+	if *autoCommit && (*tui || *preview > 0 || *matchReimburse || *detectRefunds) {
+		oerr("-auto-commit can't be used with interactive flags: -tui, -preview," +
+			" -match-reimbursements, -detect-refunds")
+		return
+	}
+	if *dupSimilarity <= 0 || *dupSimilarity > 1 {
+		oerr("Expected -dup-similarity to be within (0, 1]")
+		return
+	}
+	if *numHits < 1 {
+		oerr("Expected -top-hits to be at least 1")
+		return
+	}
+	if _, err := parseColsMap(*colsMap); err != nil {
+		oerr("Invalid -cols-map: " + err.Error())
+		return
+	}
+	if len(*openingBal) > 0 {
+		if _, err := parseOpeningBalance(*openingBal, defAccount); err != nil {
+			oerr("Invalid -opening-balance: " + err.Error())
+			return
 		}
 	}
 	keyfile := path.Join(*configDir, *shortcuts)
@@ -898,17 +2261,34 @@ func main() {
 		oerr("Please specify the output file")
 		return
 	}
+	if *inbox && (!isFlagSet("o") || samePath(*output, *journal)) {
+		*output = inboxPath(*journal)
+		fmt.Printf("Writing to inbox file: %s\n", *output)
+	} else if samePath(*output, *journal) {
+		fmt.Println("WARNING: Output file is the same as the journal. Unreviewed txns would" +
+			" be used for learning in the next run. Consider using -inbox.")
+	}
 	if _, err := os.Stat(*output); os.IsNotExist(err) {
 		_, err := os.Create(*output)
 		checkf(err, "Unable to check for output file: %v", *output)
 	}
 
-	tf, err := ioutil.TempFile("", "ledger-csv-txns")
-	checkf(err, "Unable to create temp file")
-	defer os.Remove(tf.Name())
+	dbPath := *session
+	if len(dbPath) == 0 {
+		tf, err := ioutil.TempFile("", "ledger-csv-txns")
+		checkf(err, "Unable to create temp file")
+		dbPath = tf.Name()
+		if *keepDB {
+			defer fmt.Printf("Kept boltdb at: %s\n", dbPath)
+		} else {
+			defer os.Remove(dbPath)
+		}
+	} else if _, err := os.Stat(dbPath); err == nil {
+		fmt.Printf("Resuming session from: %s\n", dbPath)
+	}
 
-	db, err := bolt.Open(tf.Name(), 0600, nil)
-	checkf(err, "Unable to open boltdb at %v", tf.Name())
+	db, err := bolt.Open(dbPath, 0600, nil)
+	checkf(err, "Unable to open boltdb at %v", dbPath)
 	defer db.Close()
 
 	db.Update(func(tx *bolt.Tx) error {
@@ -920,21 +2300,55 @@ func main() {
 	of, err := os.OpenFile(*output, os.O_APPEND|os.O_WRONLY, 0600)
 	checkf(err, "Unable to open output file: %v", *output)
 
-	p := parser{data: alldata, db: db}
+	p := parser{data: alldata, db: db, rules: rules}
+	memoryPath := path.Join(*configDir, "memory.yaml")
+	if *remember {
+		p.memory, err = loadMemory(memoryPath)
+		checkf(err, "Unable to load payee memory")
+	}
 	p.parseAccounts()
 	p.parseTransactions()
+	if *dedupOutput && !samePath(*output, *journal) {
+		// The output file might hold txns imported earlier, but not yet merged into
+		// the journal. Skip it, if ledger can't parse it.
+		if p.outTxns, err = ledgerTxns(*output); err != nil {
+			fmt.Printf("WARNING: Not deduplicating against output file: %v\n", err)
+		}
+	}
+
+	if *dedupIDs || *plaidPending {
+		p.ids = make(map[string]bool)
+		readIDs(p.ids, alldata)
+		if out, err := ioutil.ReadFile(*output); err == nil {
+			readIDs(p.ids, out)
+		}
+	}
 
 	// Scanning done. Now train classifier.
 	p.generateClasses()
 
+	if *stats {
+		p.crossValidate(*folds)
+		return
+	}
+	if *testRules {
+		p.testRules(os.Stdout)
+		return
+	}
+	if *showRecurring {
+		p.printRecurring(p.detectRecurring())
+		return
+	}
+
 	var txns []Txn
 	switch {
 	case *usePlaid:
 		var err error
 		txns, err = GetPlaidTransactions(*account)
 		checkf(err, "Couldn't get plaid txns")
-		for i := range txns {
-			txns[i].CurName = *currency
+		for _, t := range stalePending(txns, p.ids) {
+			fmt.Printf("Pending txn with id: %s has posted as id: %s. Remove its entry by hand.\n",
+				t.PendingID, t.ID)
 		}
 
 	case len(*csvFile) > 0:
@@ -942,17 +2356,64 @@ func main() {
 		checkf(err, "Unable to read csv file: %v", *csvFile)
 		txns = parseTransactionsFromCSV(in)
 
+	case len(*ofxFile) > 0:
+		in, err := ioutil.ReadFile(*ofxFile)
+		checkf(err, "Unable to read ofx file: %v", *ofxFile)
+		txns = parseTransactionsFromOFX(in)
+
+	case len(*jsonFile) > 0:
+		in, err := ioutil.ReadFile(*jsonFile)
+		checkf(err, "Unable to read json file: %v", *jsonFile)
+		txns = parseTransactionsFromJSON(in, jsonFields(c.JSON))
+
 	default:
-		assertf(false, "Please specify either a CSV flag or a Plaid flag")
+		assertf(false, "Please specify either a CSV, JSON, OFX or a Plaid flag")
+	}
+
+	inFile := *csvFile
+	if len(*jsonFile) > 0 {
+		inFile = *jsonFile
+	} else if len(*ofxFile) > 0 {
+		inFile = *ofxFile
+	}
+	curConf, err = loadCurrency(accounts, inFile)
+	checkf(err, "Unable to load currency")
+	if curConf != nil {
+		checkCommodities(p.data, curConf)
+	}
+	for i := range txns {
+		txns[i].CurName = resolveCurrency(txns[i].CurName, curConf, *currency)
+		if *outFormat == "beancount" {
+			_, err := beancountCommodity(txns[i].CurName)
+			checkf(err, "Unable to write txn in beancount format: %v", txns[i].Desc)
+		}
+	}
+	if *outFormat == "beancount" && len(*baseCurrency) > 0 {
+		_, err := beancountCommodity(*baseCurrency)
+		checkf(err, "Invalid -base-currency for beancount format")
+	}
+	if *outFormat == "beancount" && (len(*openingBal) > 0 || *plaidAssert) {
+		_, err := beancountCommodity(resolveCurrency("", curConf, *currency))
+		checkf(err, "Unable to write opening balance in beancount format")
 	}
 
 	for i := range txns {
+		acc := sourceAccount(txns[i])
 		if txns[i].Cur > 0 {
-			txns[i].To = *account
+			txns[i].To = acc
 		} else {
-			txns[i].From = *account
+			txns[i].From = acc
 		}
 	}
+	if *markCleared {
+		clearUnmarked(txns)
+	}
+	if len(*session) > 0 && !*usePlaid && len(*ofxFile) == 0 {
+		// Plaid and OFX txns already have stable keys.
+		assignSessionKeys(txns)
+	}
+	txns = filterBySign(txns, *sign)
+
 	if len(txns) > 0 {
 		sort.Sort(byTime(txns))
 		fmt.Println("Earliest and Latest transactions:")
@@ -961,7 +2422,18 @@ func main() {
 		fmt.Println()
 	}
 
+	incoming := len(txns)
 	txns = p.removeDuplicates(txns) // sorts by date.
+	if err := checkDups(incoming, len(txns)); err != nil {
+		fatalf("%v", err)
+	}
+	if *preview > 0 && len(txns) > 0 && !previewTxns(txns, *preview) {
+		return
+	}
+
+	if len(*session) > 0 {
+		txns = p.resumeSession(txns)
+	}
 
 	// Now sort by description for the rest of the categorizers.
 	sort.Slice(txns, func(i, j int) bool {
@@ -973,21 +2445,85 @@ func main() {
 		}
 		return txns[i].Date.After(txns[j].Date)
 	})
+	// Categorizers below reuse the txns slice, so retain a copy.
+	imported := append([]Txn(nil), txns...)
+	if *matchReimburse {
+		txns = p.matchReimbursements(txns)
+	}
 	txns = p.categorizeByRules(txns)
+	if p.memory != nil {
+		txns = p.categorizeByMemory(txns)
+	}
+	if *useRecurring {
+		txns = p.categorizeByRecurring(txns)
+	}
+	if *mccCol >= 0 {
+		txns = p.categorizeByMCC(txns)
+	}
+	if *detectRefunds {
+		txns = p.categorizeRefunds(txns)
+	}
 	txns = p.categorizeBelow(txns)
-	p.showAndCategorizeTxns(txns)
+	if *autoCommit {
+		txns = p.autoCategorize(txns)
+	} else if *tui {
+		p.listAndCategorizeTxns(txns)
+	} else {
+		p.showAndCategorizeTxns(txns)
+	}
 
 	final := p.iterateDB()
-	sort.Sort(byTime(final))
+	sortOutput(final, *outSort)
+	if *autoCommit && len(*uncatOut) == 0 {
+		*uncatOut = *output + ".review.csv"
+	}
+	if len(*uncatOut) > 0 {
+		p.writeUncategorized(*uncatOut, imported, final)
+	}
 
 	_, err = of.WriteString(fmt.Sprintf("; into-ledger run at %v\n\n", time.Now()))
 	checkf(err, "Unable to write into output file: %v", of.Name())
 
+	if len(*openingBal) > 0 {
+		t, err := parseOpeningBalance(*openingBal, defAccount)
+		checkf(err, "Invalid -opening-balance: %v", *openingBal)
+		t.CurName = resolveCurrency("", curConf, *currency)
+		_, err = of.WriteString(formatTxn(t))
+		checkf(err, "Unable to write into output file: %v", of.Name())
+	}
+	if *plaidAssert && len(newBalances) > 0 {
+		curName := resolveCurrency("", curConf, *currency)
+		_, err = of.WriteString(balanceAssertions(newBalances, final, curName))
+		checkf(err, "Unable to write into output file: %v", of.Name())
+	}
 	for _, t := range final {
-		if _, err := of.WriteString(ledgerFormat(t)); err != nil {
-			log.Fatalf("Unable to write to output: %v", err)
+		if _, err := of.WriteString(formatTxn(t)); err != nil {
+			fatalf("Unable to write to output: %v", err)
 		}
 	}
+	if len(p.dropped) > 0 {
+		fmt.Printf("%d txns were marked as duplicates during review and dropped.\n", len(p.dropped))
+	}
 	fmt.Printf("Transactions written to file: %s\n", of.Name())
 	checkf(of.Close(), "Unable to close output file: %v", of.Name())
+	if p.memory != nil {
+		// Only remember the txns written out, so the ones undone or dropped during
+		// review are left out.
+		for _, t := range final {
+			p.rememberTxn(t)
+		}
+		checkf(saveMemory(memoryPath, p.memory), "Unable to save payee memory at %v", memoryPath)
+	}
+	if *usePlaid {
+		checkf(savePlaidCursors(), "Unable to save plaid cursors")
+	}
+	if len(*session) > 0 && !*keepDB {
+		// The txns are now in the output file, so the session is complete.
+		checkf(db.Close(), "Unable to close boltdb at %v", dbPath)
+		checkf(os.Remove(dbPath), "Unable to remove session: %v", dbPath)
+	}
+
+	if len(*postHook) > 0 {
+		runPostHook(*postHook, of.Name())
+	}
 }
@@ -0,0 +1,902 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestParser returns a parser backed by a temporary boltdb, and a function to
+// clean it up.
+func newTestParser(t *testing.T) (*parser, func()) {
+	f, err := ioutil.TempFile("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &parser{db: db}, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	configs := map[string]map[string]string{
+		"chase": {"j": "journal.ldg", "c": "USD"},
+		"amex":  {"j": "journal.ldg", "o": "amex.out"},
+		"hsbc":  {"c": "GBP"},
+	}
+	tests := []struct {
+		accounts []string
+		want     map[string]string
+		ok       bool
+	}{
+		{[]string{"chase"}, map[string]string{"j": "journal.ldg", "c": "USD"}, true},
+		{[]string{"unknown"}, map[string]string{}, true},
+		{[]string{"chase", "amex"},
+			map[string]string{"j": "journal.ldg", "c": "USD", "o": "amex.out"}, true},
+		{[]string{"chase", "hsbc"}, nil, false},
+	}
+	for _, tc := range tests {
+		got, err := configFlags(configs, tc.accounts)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: got error %v, want ok=%v", tc.accounts, err, tc.ok)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.accounts, got, tc.want)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%v: got %s=%q, want %q", tc.accounts, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestClearUnmarked(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"", "2024/03/01 *\tCOFFEE\n"},
+		{statusPending, "2024/03/01 !\tCOFFEE\n"},
+		{statusCleared, "2024/03/01 *\tCOFFEE\n"},
+	}
+	for _, tc := range tests {
+		txns := []Txn{{Date: date, Desc: "COFFEE", To: "Expenses:Coffee", From: "Assets:Bank",
+			Cur: -3, CurName: "$", Status: tc.status}}
+		clearUnmarked(txns)
+		if got := ledgerFormat(txns[0]); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("status %q: got %q, want prefix %q", tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestParseTypeColumn(t *testing.T) {
+	defer func(v int) { *typeCol = v }(*typeCol)
+	*typeCol = 2
+
+	tests := []struct {
+		line string
+		want float64
+	}{
+		{"03/01/2024,COFFEE,Debit,4.50", -4.5},
+		{"03/01/2024,COFFEE,DR,-4.50", -4.5},
+		{"03/01/2024,REFUND,credit,-4.50", 4.5},
+		{"03/01/2024,REFUND, Deposit ,4.50", 4.5},
+		{"03/01/2024,COFFEE,unknown,4.50", 4.5},
+	}
+	for _, tc := range tests {
+		txns := parseTransactionsFromCSV([]byte(tc.line))
+		if len(txns) != 1 {
+			t.Fatalf("%q: got %d txns, want 1", tc.line, len(txns))
+		}
+		if txns[0].Cur != tc.want {
+			t.Errorf("%q: got amount %v, want %v", tc.line, txns[0].Cur, tc.want)
+		}
+	}
+}
+
+func TestParseKeepRaw(t *testing.T) {
+	defer func(v bool) { *keepRaw = v }(*keepRaw)
+	line := `03/01/2024,"COFFEE, TO GO",4.50`
+
+	tests := []struct {
+		keep bool
+		want string
+	}{
+		{false, ""},
+		{true, "03/01/2024|COFFEE, TO GO|4.50"},
+	}
+	for _, tc := range tests {
+		*keepRaw = tc.keep
+		txns := parseTransactionsFromCSV([]byte(line))
+		if len(txns) != 1 {
+			t.Fatalf("keep=%v: got %d txns, want 1", tc.keep, len(txns))
+		}
+		if got := strings.Join(txns[0].RawRow, "|"); got != tc.want {
+			t.Errorf("keep=%v: got raw row %q, want %q", tc.keep, got, tc.want)
+		}
+	}
+}
+
+func TestMarkDuplicate(t *testing.T) {
+	p, cleanup := newTestParser(t)
+	defer cleanup()
+
+	txns := []Txn{
+		{Key: []byte("k1"), Desc: "COFFEE", To: "Expenses:Coffee", Cur: -3, Done: true},
+		{Key: []byte("k2"), Desc: "COFFEE", To: "Expenses:Coffee", Cur: -3, Done: true},
+	}
+	for _, txn := range txns {
+		p.writeToDB(txn)
+	}
+
+	tests := []struct {
+		action  func()
+		stored  string
+		dropped int
+	}{
+		{func() { p.markDuplicate(&txns[1]) }, "k1", 1},
+		// Going back and categorizing the txn again undoes .dup.
+		{func() { p.writeToDB(txns[1]) }, "k1,k2", 0},
+		{func() { p.markDuplicate(&txns[0]) }, "k2", 1},
+	}
+	for i, tc := range tests {
+		tc.action()
+		var keys []string
+		for _, txn := range p.iterateDB() {
+			keys = append(keys, string(txn.Key))
+		}
+		if got := strings.Join(keys, ","); got != tc.stored {
+			t.Errorf("step %d: got stored txns %s, want %s", i, got, tc.stored)
+		}
+		if len(p.dropped) != tc.dropped {
+			t.Errorf("step %d: got %d dropped txns, want %d", i, len(p.dropped), tc.dropped)
+		}
+	}
+	if txns[0].Done {
+		t.Errorf("txn marked as duplicate is still done")
+	}
+}
+
+func TestSetAccount(t *testing.T) {
+	tests := []struct {
+		cur    float64
+		source bool
+		to     string
+		from   string
+		prefix string
+	}{
+		{-3, false, "Expenses:Coffee", "Assets:Bank", "[FROM]"},
+		{-3, true, "Expenses:Food", "Liabilities:Card", "[FROM]"},
+		{3, false, "Assets:Bank", "Expenses:Coffee", "[TO]"},
+		{3, true, "Liabilities:Card", "Expenses:Food", "[TO]"},
+	}
+	for _, tc := range tests {
+		txn := Txn{Cur: tc.cur, To: "Expenses:Food", From: "Assets:Bank"}
+		if tc.cur > 0 {
+			txn.To, txn.From = "Assets:Bank", "Expenses:Food"
+		}
+		acc := "Expenses:Coffee"
+		if tc.source {
+			acc = "Liabilities:Card"
+		}
+		setAccount(&txn, acc, tc.source)
+		if txn.To != tc.to || txn.From != tc.from {
+			t.Errorf("cur %v, source %v: got to %q from %q, want to %q from %q",
+				tc.cur, tc.source, txn.To, txn.From, tc.to, tc.from)
+		}
+		if prefix, src := getSource(txn); prefix != tc.prefix || (src == acc) != tc.source {
+			t.Errorf("cur %v, source %v: got source %s %s", tc.cur, tc.source, prefix, src)
+		}
+	}
+}
+
+func TestDiffTxn(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	existing := Txn{Date: date, Desc: "STARBUCKS", Cur: -4.5, CurName: "$"}
+	tests := []struct {
+		incoming Txn
+		fields   []string
+	}{
+		{Txn{Date: date, Desc: "STARBUCKS", Cur: -4.5, CurName: "$"}, nil},
+		{Txn{Date: date, Desc: "STARBUCKS", Cur: 4.5}, nil},
+		{Txn{Date: date.AddDate(0, 0, 1), Desc: "STARBUCKS #12", Cur: -4.5}, []string{"Date", "Desc"}},
+		{Txn{Date: date, Desc: "STARBUCKS", Cur: -4.55, CurName: "EUR"}, []string{"Amount", "Currency"}},
+	}
+	for _, tc := range tests {
+		lines := diffTxn(existing, tc.incoming)
+		var fields []string
+		for _, line := range lines {
+			fields = append(fields, strings.Fields(line)[0])
+		}
+		if strings.Join(fields, ",") != strings.Join(tc.fields, ",") {
+			t.Errorf("%+v: got diff %q, want fields %v", tc.incoming, lines, tc.fields)
+		}
+	}
+}
+
+func TestInPreview(t *testing.T) {
+	tests := []struct {
+		total int
+		n     int
+		want  string
+	}{
+		{10, 2, "0,1,8,9"},
+		{5, 2, "0,1,3,4"},
+		{3, 2, "0,1,2"},
+		{4, 5, "0,1,2,3"},
+		{1, 1, "0"},
+	}
+	for _, tc := range tests {
+		var shown []string
+		for i := 0; i < tc.total; i++ {
+			if inPreview(i, tc.total, tc.n) {
+				shown = append(shown, strconv.Itoa(i))
+			}
+		}
+		if got := strings.Join(shown, ","); got != tc.want {
+			t.Errorf("total %d, n %d: got %s, want %s", tc.total, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestWriteUncategorized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := path.Join(dir, "uncategorized.csv")
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	imported := []Txn{
+		{Key: []byte("k1"), Date: date, Desc: "COFFEE", Cur: -3, From: "Assets:Bank"},
+		{Key: []byte("k2"), Date: date, Desc: "UNKNOWN", Cur: -12.5, From: "Assets:Bank"},
+		{Key: []byte("k3"), Date: date, Desc: "DUP", Cur: -3, From: "Assets:Bank"},
+		{Key: []byte("k4"), Date: date, Desc: "REFUND, PARTIAL", Cur: 7, To: "Assets:Bank"},
+	}
+	tests := []struct {
+		final   []Txn
+		dropped map[string]bool
+		want    string
+	}{
+		{imported, nil, "date,desc,amount,source\n"},
+		{imported[:1], map[string]bool{"k3": true}, "date,desc,amount,source\n" +
+			"2024/03/01,UNKNOWN,-12.50,Assets:Bank\n" +
+			"2024/03/01,\"REFUND, PARTIAL\",7.00,Assets:Bank\n"},
+	}
+	for i, tc := range tests {
+		p := parser{dropped: tc.dropped}
+		p.writeUncategorized(fname, imported, tc.final)
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.want {
+			t.Errorf("case %d: got:\n%s\nwant:\n%s", i, data, tc.want)
+		}
+	}
+}
+
+func TestInboxPath(t *testing.T) {
+	tests := []struct {
+		journal string
+		output  string
+		inbox   string
+		same    bool
+	}{
+		{"journal.ldg", "journal.ldg", "journal.inbox.ldg", true},
+		{"/home/me/ledger/main.ledger", "/home/me/ledger/../ledger/main.ledger",
+			"/home/me/ledger/main.inbox.ledger", true},
+		{"journal.ldg", "./journal.ldg", "journal.inbox.ldg", true},
+		{"journal", "out.ldg", "journal.inbox", false},
+		{"a.b/journal.ldg", "a.b/journal.inbox.ldg", "a.b/journal.inbox.ldg", false},
+	}
+	for _, tc := range tests {
+		if got := inboxPath(tc.journal); got != tc.inbox {
+			t.Errorf("inboxPath(%q) = %q, want %q", tc.journal, got, tc.inbox)
+		}
+		if got := samePath(tc.output, tc.journal); got != tc.same {
+			t.Errorf("samePath(%q, %q) = %v, want %v", tc.output, tc.journal, got, tc.same)
+		}
+	}
+}
+
+// withStdin replaces stdin with the input, and returns a function to restore it.
+func withStdin(t *testing.T, input string) func() {
+	f, err := ioutil.TempFile("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	return func() {
+		os.Stdin = stdin
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestReadEOF(t *testing.T) {
+	tests := []struct {
+		input   string
+		line    string
+		lineOk  bool
+		proceed bool
+	}{
+		{"", "", false, false},
+		{"Expenses:Food\ny", "Expenses:Food", true, true},
+		{"Expenses:Food\nn", "Expenses:Food", true, false},
+		{"Expenses:Fo", "", false, false},
+		{"\n\n", "", true, true},
+	}
+	txns := []Txn{{Desc: "COFFEE", Cur: -3}}
+	for _, tc := range tests {
+		restore := withStdin(t, tc.input)
+		line, ok := readLine()
+		proceed := previewTxns(txns, 1)
+		restore()
+		if line != tc.line || ok != tc.lineOk {
+			t.Errorf("%q: got line %q, %v. Want %q, %v", tc.input, line, ok, tc.line, tc.lineOk)
+		}
+		if proceed != tc.proceed {
+			t.Errorf("%q: got proceed %v, want %v", tc.input, proceed, tc.proceed)
+		}
+	}
+}
+
+func TestSortOutput(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	txns := []Txn{
+		{Desc: "a", Date: date(3), To: "Expenses:Food", Cur: -5, Index: 2},
+		{Desc: "b", Date: date(1), To: "Expenses:Travel", Cur: -50, Index: 0},
+		{Desc: "c", Date: date(2), To: "Expenses:Food", Cur: 20, Index: 3},
+		{Desc: "d", Date: date(1), To: "Expenses:Food", Cur: -1, Index: 1},
+	}
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"date", "bdca"},
+		{"account-date", "dcab"},
+		{"csv-order", "bdac"},
+		{"amount", "bcad"},
+	}
+	for _, tc := range tests {
+		sorted := append([]Txn(nil), txns...)
+		sortOutput(sorted, tc.order)
+		var got string
+		for _, txn := range sorted {
+			got += txn.Desc
+		}
+		if got != tc.want {
+			t.Errorf("%s: got order %s, want %s", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestParseNoteColumn(t *testing.T) {
+	defer func(v int) { *noteCol = v }(*noteCol)
+	*noteCol = 3
+
+	tests := []struct {
+		line string
+		note string
+		want string
+	}{
+		{`03/01/2024,DINNER,45.00,Team offsite`, "Team offsite", "\t; Team offsite\n"},
+		{"03/01/2024,DINNER,45.00,\" two\nlines \"", "two\nlines", "\t; two lines\n"},
+		{`03/01/2024,DINNER,45.00,`, "", ""},
+	}
+	for _, tc := range tests {
+		txns := parseTransactionsFromCSV([]byte(tc.line))
+		if len(txns) != 1 {
+			t.Fatalf("%q: got %d txns, want 1", tc.line, len(txns))
+		}
+		if txns[0].Note != tc.note {
+			t.Errorf("%q: got note %q, want %q", tc.line, txns[0].Note, tc.note)
+		}
+		out := ledgerFormat(txns[0])
+		if len(tc.want) > 0 && !strings.Contains(out, tc.want) {
+			t.Errorf("%q: got %q, want it to contain %q", tc.line, out, tc.want)
+		}
+		if len(tc.want) == 0 && strings.Contains(out, ";") {
+			t.Errorf("%q: got comment in %q", tc.line, out)
+		}
+	}
+}
+
+func TestPostHookCmd(t *testing.T) {
+	out := "/tmp/out dir/new.ldg"
+	tests := []struct {
+		hook string
+		want string
+		ok   bool
+	}{
+		{`echo "$1"`, out + "\n", true},
+		{`echo "$INTO_LEDGER_OUTPUT"`, out + "\n", true},
+		{`test "$1" = "$INTO_LEDGER_OUTPUT" && echo same`, "same\n", true},
+		{`echo failed; exit 3`, "failed\n", false},
+	}
+	for _, tc := range tests {
+		res, err := postHookCmd(tc.hook, out).CombinedOutput()
+		if string(res) != tc.want || (err == nil) != tc.ok {
+			t.Errorf("%q: got %q, %v. Want %q, ok=%v", tc.hook, res, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRemoveDuplicatesWindow(t *testing.T) {
+	defer func(v int) { *dupWithin = v }(*dupWithin)
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	p := parser{txns: []Txn{
+		{Date: date(1), Desc: "STARBUCKS", Cur: -4.5},
+		{Date: date(10), Desc: "RENT", Cur: -2000},
+	}}
+
+	tests := []struct {
+		within int
+		txn    Txn
+		dup    bool
+	}{
+		{24, Txn{Date: date(1), Desc: "STARBUCKS", Cur: -4.5}, true},
+		{24, Txn{Date: date(2), Desc: "STARBUCKS", Cur: -4.5}, true},
+		{24, Txn{Date: date(3), Desc: "STARBUCKS", Cur: -4.5}, false},
+		{24, Txn{Date: date(2), Desc: "STARBUCKS", Cur: -4.6}, false},
+		{24, Txn{Date: date(13), Desc: "RENT", Cur: -2000}, false},
+		// The look-back into the journal grows along with the window.
+		{72, Txn{Date: date(4), Desc: "STARBUCKS", Cur: -4.5}, true},
+		{72, Txn{Date: date(13), Desc: "RENT", Cur: -2000}, true},
+		{72, Txn{Date: date(14), Desc: "RENT", Cur: -2000}, false},
+		{0, Txn{Date: date(2), Desc: "STARBUCKS", Cur: -4.5}, false},
+		{0, Txn{Date: date(10), Desc: "RENT", Cur: -2000}, true},
+	}
+	for _, tc := range tests {
+		*dupWithin = tc.within
+		final := p.removeDuplicates([]Txn{tc.txn})
+		if got := len(final) == 0; got != tc.dup {
+			t.Errorf("within %d, %s on %s: got dup %v, want %v",
+				tc.within, tc.txn.Desc, tc.txn.Date.Format(stamp), got, tc.dup)
+		}
+	}
+}
+
+func TestTruncateAccount(t *testing.T) {
+	tests := []struct {
+		acc   string
+		depth int
+		want  string
+	}{
+		{"Expenses:Food:Groceries:Organic", 0, "Expenses:Food:Groceries:Organic"},
+		{"Expenses:Food:Groceries:Organic", -1, "Expenses:Food:Groceries:Organic"},
+		{"Expenses:Food:Groceries:Organic", 1, "Expenses"},
+		{"Expenses:Food:Groceries:Organic", 2, "Expenses:Food"},
+		{"Expenses:Food:Groceries", 3, "Expenses:Food:Groceries"},
+		{"Expenses:Food", 3, "Expenses:Food"},
+	}
+	for _, tc := range tests {
+		if got := truncateAccount(tc.acc, tc.depth); got != tc.want {
+			t.Errorf("truncateAccount(%q, %d) = %q, want %q", tc.acc, tc.depth, got, tc.want)
+		}
+	}
+}
+
+func TestTrainDepth(t *testing.T) {
+	defer func(d int, c string) { *depth, *classifier = d, c }(*depth, *classifier)
+	*classifier = "centroid"
+	txns := []Txn{
+		{Desc: "SAFEWAY", To: "Expenses:Food:Groceries"},
+		{Desc: "CHEZ PANISSE", To: "Expenses:Food:Restaurants"},
+		{Desc: "UBER", To: "Expenses:Travel:Taxi"},
+		{Desc: "ATM", To: "Assets:Cash", skipClassification: true},
+	}
+	tests := []struct {
+		depth   int
+		classes int
+	}{
+		{0, 3},
+		{3, 3},
+		{2, 2},
+	}
+	for _, tc := range tests {
+		*depth = tc.depth
+		var p parser
+		p.train(txns)
+		if len(p.classes) != tc.classes {
+			t.Errorf("depth %d: got classes %v, want %d", tc.depth, p.classes, tc.classes)
+		}
+		for _, class := range p.classes {
+			if tc.depth > 0 && strings.Count(string(class), ":") >= tc.depth {
+				t.Errorf("depth %d: got class %s", tc.depth, class)
+			}
+		}
+	}
+}
+
+func TestParseAmountWithCurrency(t *testing.T) {
+	tests := []struct {
+		col string
+		amt float64
+		cur string
+		ok  bool
+	}{
+		{"$45.00", 45, "$", true},
+		{"-$45.00", -45, "$", true},
+		{"$-45.00", -45, "$", true},
+		{"USD 45", 45, "USD", true},
+		{"45.00 EUR", 45, "EUR", true},
+		{" -1,234.56€ ", -1234.56, "€", true},
+		{"45", 45, "", true},
+		{"USD 45 EUR", 0, "", false},
+		{"--45", 0, "", false},
+		{"-$-45", 0, "", false},
+		{"$", 0, "", false},
+		{"", 0, "", false},
+	}
+	for _, tc := range tests {
+		amt, cur, ok := parseAmountWithCurrency(tc.col)
+		if amt != tc.amt || cur != tc.cur || ok != tc.ok {
+			t.Errorf("%q: got %v, %q, %v. Want %v, %q, %v",
+				tc.col, amt, cur, ok, tc.amt, tc.cur, tc.ok)
+		}
+	}
+}
+
+func TestParseStatusColumn(t *testing.T) {
+	defer func(v int) { *statusCol = v }(*statusCol)
+	*statusCol = 2
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"03/01/2024,COFFEE,Posted,4.50", statusCleared},
+		{"03/01/2024,COFFEE, SETTLED ,4.50", statusCleared},
+		{"03/01/2024,COFFEE,pending,4.50", statusPending},
+		{"03/01/2024,COFFEE,Authorized,4.50", statusPending},
+		{"03/01/2024,COFFEE,reversed,4.50", ""},
+		{"03/01/2024,COFFEE,,4.50", ""},
+	}
+	for _, tc := range tests {
+		txns := parseTransactionsFromCSV([]byte(tc.line))
+		if len(txns) != 1 {
+			t.Fatalf("%q: got %d txns, want 1", tc.line, len(txns))
+		}
+		if txns[0].Status != tc.want {
+			t.Errorf("%q: got status %q, want %q", tc.line, txns[0].Status, tc.want)
+		}
+		if txns[0].Desc != "COFFEE" || txns[0].Cur != 4.5 {
+			t.Errorf("%q: status column leaked into %+v", tc.line, txns[0])
+		}
+	}
+}
+
+func TestNormalizeCentury(t *testing.T) {
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	year := func(y int) time.Time { return time.Date(y, 3, 1, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		tm   time.Time
+		want int
+	}{
+		{year(2024), 2024},
+		{year(2080), 1980},
+		{year(2076), 2076},
+		{year(2077), 1977},
+		{year(1999), 1999},
+		{year(1975), 2075},
+		{year(1899), 1999},
+	}
+	for _, tc := range tests {
+		if got := normalizeCentury(tc.tm, now).Year(); got != tc.want {
+			t.Errorf("normalizeCentury(%d) = %d, want %d", tc.tm.Year(), got, tc.want)
+		}
+	}
+}
+
+func TestParseTwoDigitYear(t *testing.T) {
+	defer func(v string) { *dateFormat = v }(*dateFormat)
+	tests := []struct {
+		layout string
+		col    string
+		two    bool
+		want   string
+	}{
+		{"01/02/06", "03/01/24", true, "2024/03/01"},
+		{"01/02/06", "12/31/99", true, "1999/12/31"},
+		{"02-Jan-06", "01-Mar-24", true, "2024/03/01"},
+		{"01/02/2006", "03/01/2024", false, "2024/03/01"},
+		// Four digit years are left alone.
+		{"2006/01/02", "2090/03/01", false, "2090/03/01"},
+	}
+	for _, tc := range tests {
+		if got := hasTwoDigitYear(tc.layout); got != tc.two {
+			t.Errorf("hasTwoDigitYear(%q) = %v, want %v", tc.layout, got, tc.two)
+		}
+		*dateFormat = tc.layout
+		tm, ok := parseDate(tc.col)
+		if !ok || tm.Format(stamp) != tc.want {
+			t.Errorf("%q with %q: got %v, %v. Want %s", tc.col, tc.layout, tm, ok, tc.want)
+		}
+	}
+}
+
+func TestDumpTxns(t *testing.T) {
+	p, cleanup := newTestParser(t)
+	defer cleanup()
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	txns := []Txn{
+		{Key: []byte("k1"), Date: date, Desc: "COFFEE", To: "Expenses:Coffee", Cur: -3},
+		{Key: []byte("k2"), Date: date, Desc: "SALARY", From: "Income:Salary", Cur: 1000},
+	}
+	for _, txn := range txns {
+		p.writeToDB(txn)
+	}
+	fpath := p.db.Path()
+	if err := p.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	dumpTxns(&b, fpath)
+	dec := json.NewDecoder(&b)
+	for _, want := range txns {
+		var got Txn
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Unable to decode dumped txn: %v", err)
+		}
+		if got.Desc != want.Desc || got.Cur != want.Cur || !got.Date.Equal(want.Date) ||
+			got.To != want.To || got.From != want.From {
+			t.Errorf("got dumped txn %+v, want %+v", got, want)
+		}
+	}
+	if dec.More() {
+		t.Errorf("got more dumped txns than written")
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		col  string
+		want float64
+		ok   bool
+	}{
+		{"45.00", 45, true},
+		{"-45.00", -45, true},
+		{"(45.00)", -45, true},
+		{" ($1,234.56) ", -1234.56, true},
+		{"$1,234.56", 1234.56, true},
+		{"-$12.00", -12, true},
+		{"(-45.00)", 0, false},
+		{"((45.00))", 0, false},
+		{"(45.00", 0, false},
+		{"()", 0, false},
+		{"COFFEE", 0, false},
+	}
+	for _, tc := range tests {
+		got, ok := parseCurrency(tc.col)
+		if ok != tc.ok || (ok && got != tc.want) {
+			t.Errorf("parseCurrency(%q) = %v, %v. Want %v, %v", tc.col, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCheckDups(t *testing.T) {
+	defer func(f, e bool) { *failOnDups, *expectNew = f, e }(*failOnDups, *expectNew)
+	tests := []struct {
+		failOnDups bool
+		expectNew  bool
+		incoming   int
+		fresh      int
+		ok         bool
+	}{
+		{false, false, 5, 0, true},
+		{true, false, 5, 5, true},
+		{true, false, 5, 4, false},
+		{true, false, 0, 0, true},
+		{false, true, 5, 1, true},
+		{false, true, 5, 0, false},
+		{false, true, 0, 0, false},
+		{true, true, 5, 5, true},
+	}
+	for _, tc := range tests {
+		*failOnDups, *expectNew = tc.failOnDups, tc.expectNew
+		if err := checkDups(tc.incoming, tc.fresh); (err == nil) != tc.ok {
+			t.Errorf("%+v: got error %v", tc, err)
+		}
+	}
+}
+
+func TestFilterBySign(t *testing.T) {
+	txns := []Txn{
+		{Desc: "a", Cur: -5},
+		{Desc: "b", Cur: 10},
+		{Desc: "c", Cur: -1},
+		{Desc: "d", Cur: 3},
+	}
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"both", "abcd"},
+		{"debit", "ac"},
+		{"credit", "bd"},
+	}
+	for _, tc := range tests {
+		var got string
+		for _, txn := range filterBySign(append([]Txn(nil), txns...), tc.mode) {
+			got += txn.Desc
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestSelectionResume(t *testing.T) {
+	labels := map[string]bool{"default": true, "Expenses": true}
+	hasLabel := func(label string) bool { return labels[label] }
+	tests := []struct {
+		sel      selection
+		label    string
+		category string
+	}{
+		{selection{}, "default", ""},
+		{selection{label: "Expenses", category: []string{"Expenses"}}, "Expenses", "Expenses"},
+		{selection{label: "Food", category: []string{"Expenses", "Food"}}, "default", ""},
+		{selection{label: "Expenses", category: []string{"Expenses"}, source: true},
+			"Expenses", "Expenses"},
+	}
+	for _, tc := range tests {
+		sel := tc.sel
+		sel.resume(hasLabel)
+		if sel.label != tc.label || strings.Join(sel.category, ":") != tc.category {
+			t.Errorf("%+v: got label %q, category %v. Want %q, %q",
+				tc.sel, sel.label, sel.category, tc.label, tc.category)
+		}
+		if sel.source != tc.sel.source {
+			t.Errorf("%+v: source changed on resume", tc.sel)
+		}
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	defer func(v int) { *skip = v }(*skip)
+	tests := []struct {
+		in   string
+		skip int
+	}{
+		{"\xef\xbb\xbf03/01/2024,COFFEE,4.50\n", 0},
+		{"\xef\xbb\xbfDate,Desc,Amount\n03/01/2024,COFFEE,4.50\n", 1},
+		{"03/01/2024,COFFEE,4.50\n", 0},
+		{"\xef\xbb\xbf\"03/01/2024\",COFFEE,4.50\n", 0},
+	}
+	for _, tc := range tests {
+		*skip = tc.skip
+		txns := parseTransactionsFromCSV([]byte(tc.in))
+		if len(txns) != 1 {
+			t.Fatalf("%q: got %d txns, want 1", tc.in, len(txns))
+		}
+		want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		if !txns[0].Date.Equal(want) || txns[0].Desc != "COFFEE" {
+			t.Errorf("%q: got %+v", tc.in, txns[0])
+		}
+	}
+}
+
+func TestParseOpeningBalance(t *testing.T) {
+	tests := []struct {
+		spec    string
+		account string
+		to      string
+		from    string
+		ok      bool
+	}{
+		{"2024/01/01,100.00", "Assets:Bank", "Assets:Bank", openingAccount, true},
+		{"2024/01/01, -250", "Liabilities:Card", openingAccount, "Liabilities:Card", true},
+		{"2024/01/01,(250.00),Liabilities:Amex", "", openingAccount, "Liabilities:Amex", true},
+		{"2024/01/01,100,Assets:Savings", "Assets:Bank", "Assets:Savings", openingAccount, true},
+		{"2024/01/01,100", "", "", "", false},
+		{"2024/01/01,0", "Assets:Bank", "", "", false},
+		{"01/01/2024,100", "Assets:Bank", "", "", false},
+		{"2024/01/01", "Assets:Bank", "", "", false},
+		{"2024/01/01,100,Assets:Bank,extra", "Assets:Bank", "", "", false},
+	}
+	for _, tc := range tests {
+		txn, err := parseOpeningBalance(tc.spec, tc.account)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: got error %v, want ok=%v", tc.spec, err, tc.ok)
+			continue
+		}
+		if tc.ok && (txn.To != tc.to || txn.From != tc.from) {
+			t.Errorf("%q: got to %q from %q, want to %q from %q",
+				tc.spec, txn.To, txn.From, tc.to, tc.from)
+		}
+	}
+
+	txn, err := parseOpeningBalance("2024/01/01,-250.00", "Liabilities:Card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.CurName = "$"
+	want := "2024/01/01 *\tOpening Balance\n" +
+		"\tEquity:Opening Balances\t250.00$\n" +
+		"\tLiabilities:Card\n\n"
+	if got := ledgerFormat(txn); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	defer func(a, d, ic string, s int) {
+		*account, *dateFormat, *ignore, *skip = a, d, ic, s
+	}(*account, *dateFormat, *ignore, *skip)
+
+	c := configs{
+		Accounts: map[string]map[string]string{
+			"chase": {"d": "2006-01-02", "s": "2"},
+		},
+		Profiles: map[string]map[string]string{
+			"chase-csv": {"a": "chase", "d": "01/02/2006", "ic": "0,1"},
+			"broken":    {"s": "two"},
+		},
+	}
+	tests := []struct {
+		profile string
+		ok      bool
+		date    string
+		ignore  string
+		skip    int
+	}{
+		{"", true, "2006-01-02", "", 2},
+		{"chase-csv", true, "01/02/2006", "0,1", 2},
+		{"unknown", false, "", "", 0},
+		{"broken", false, "", "", 0},
+	}
+	for _, tc := range tests {
+		*account, *dateFormat, *ignore, *skip = "", "", "", 0
+		pf, err := applyProfile(c, tc.profile)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: got error %v, want ok=%v", tc.profile, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if len(*account) == 0 {
+			// Not picked by the profile, so passed via -a.
+			*account = "chase"
+		}
+		applyAccountFlags(c.Accounts[*account], pf)
+		if *account != "chase" || *dateFormat != tc.date || *ignore != tc.ignore || *skip != tc.skip {
+			t.Errorf("%q: got -a %q -d %q -ic %q -s %d", tc.profile, *account, *dateFormat, *ignore, *skip)
+		}
+	}
+}
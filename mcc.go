@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var mccCol = flag.Int("mcc-col", -1, "Column in CSV containing the merchant category code."+
+	" Used along with mcc_map.yaml in conf dir.")
+
+// loadMCCMap returns the mapping from merchant category code to category. A
+// missing file results in no mapping.
+func loadMCCMap(fpath string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+	mccs := make(map[string]string)
+	if err := yaml.Unmarshal(data, &mccs); err != nil {
+		return nil, fmt.Errorf("Unable to parse mcc map at %s: %v", fpath, err)
+	}
+	return mccs, nil
+}
+
+// categorizeByMCC would use a mcc_map.yaml file in this format:
+// "5411": Expenses:Food:Groceries
+// "5812": Expenses:Food:Restaurants
+// ...
+// If this file is present, txns would be auto-categorized, if their merchant
+// category code is mapped.
+func (p *parser) categorizeByMCC(txns []Txn) []Txn {
+	mccs, err := loadMCCMap(path.Join(*configDir, "mcc_map.yaml"))
+	checkf(err, "Unable to load mcc map")
+
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		cat, has := mccs[t.MCC]
+		if !has || len(t.MCC) == 0 {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		if t.Cur > 0 {
+			t.From = cat
+		} else {
+			t.To = cat
+		}
+		count++
+		printSummary(t, count, count)
+		p.writeToDB(t)
+	}
+	fmt.Printf("\t%d txns have been categorized based on MCC.\n\n", count)
+	return unmatched
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCategorizeByMCC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { *configDir = d }(*configDir)
+	*configDir = dir
+	mccs := "\"5411\": Expenses:Food:Groceries\n\"5812\": Expenses:Food:Restaurants\n"
+	if err := ioutil.WriteFile(path.Join(dir, "mcc_map.yaml"), []byte(mccs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, cleanup := newTestParser(t)
+	defer cleanup()
+	txns := []Txn{
+		{Key: []byte("k1"), Desc: "SAFEWAY", MCC: "5411", Cur: -40},
+		{Key: []byte("k2"), Desc: "CHEZ PANISSE", MCC: "5812", Cur: -90},
+		{Key: []byte("k3"), Desc: "SAFEWAY REFUND", MCC: "5411", Cur: 10},
+		{Key: []byte("k4"), Desc: "HARDWARE", MCC: "5251", Cur: -15},
+		{Key: []byte("k5"), Desc: "VENMO", Cur: -20},
+	}
+	unmatched := p.categorizeByMCC(txns)
+
+	var descs []string
+	for _, txn := range unmatched {
+		descs = append(descs, txn.Desc)
+	}
+	if got := strings.Join(descs, ","); got != "HARDWARE,VENMO" {
+		t.Errorf("got unmatched %s, want HARDWARE,VENMO", got)
+	}
+
+	tests := map[string]string{
+		"SAFEWAY":        "Expenses:Food:Groceries",
+		"CHEZ PANISSE":   "Expenses:Food:Restaurants",
+		"SAFEWAY REFUND": "Expenses:Food:Groceries",
+	}
+	stored := p.iterateDB()
+	if len(stored) != len(tests) {
+		t.Fatalf("got %d categorized txns, want %d", len(stored), len(tests))
+	}
+	for _, txn := range stored {
+		got := txn.To
+		if txn.Cur > 0 {
+			got = txn.From
+		}
+		if got != tests[txn.Desc] {
+			t.Errorf("%s: got category %q, want %q", txn.Desc, got, tests[txn.Desc])
+		}
+	}
+}
+
+func TestParseMCCColumn(t *testing.T) {
+	defer func(v int) { *mccCol = v }(*mccCol)
+	*mccCol = 2
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"03/01/2024,SAFEWAY,5411,40.00", "5411"},
+		{"03/01/2024,SAFEWAY, 5411 ,40.00", "5411"},
+		{"03/01/2024,SAFEWAY,,40.00", ""},
+	}
+	for _, tc := range tests {
+		txns := parseTransactionsFromCSV([]byte(tc.line))
+		if len(txns) != 1 || txns[0].MCC != tc.want {
+			t.Errorf("%q: got %+v, want MCC %q", tc.line, txns, tc.want)
+		}
+		if len(txns) == 1 && txns[0].Cur != 40 {
+			t.Errorf("%q: got amount %v, want 40", tc.line, txns[0].Cur)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var remember = flag.Bool("remember", false, "Remember the category of each payee in"+
+	" memory.yaml in conf dir, and auto-categorize txns from known payees.")
+
+// payeeMemory stores the categories last used for a payee.
+type payeeMemory struct {
+	Debit  string `yaml:"debit,omitempty"`
+	Credit string `yaml:"credit,omitempty"`
+}
+
+func payeeKey(desc string) string {
+	return strings.ToLower(strings.Join(strings.Fields(desc), " "))
+}
+
+func loadMemory(fpath string) (map[string]payeeMemory, error) {
+	memory := make(map[string]payeeMemory)
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return memory, nil
+	}
+	if err := yaml.Unmarshal(data, &memory); err != nil {
+		return nil, fmt.Errorf("Unable to parse memory at %s: %v", fpath, err)
+	}
+	return memory, nil
+}
+
+func saveMemory(fpath string, memory map[string]payeeMemory) error {
+	data, err := yaml.Marshal(memory)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fpath, data, 0644)
+}
+
+// rememberTxn stores the category of the txn against its payee. It's called for
+// the txns written to output, at the end of the run.
+func (p *parser) rememberTxn(t Txn) {
+	if p.memory == nil {
+		return
+	}
+	_, cat := getCategory(t)
+	if len(cat) == 0 {
+		return
+	}
+	key := payeeKey(t.Desc)
+	m := p.memory[key]
+	if t.Cur > 0 {
+		m.Credit = cat
+	} else {
+		m.Debit = cat
+	}
+	p.memory[key] = m
+}
+
+// categorizeByMemory auto-categorizes txns from payees seen in earlier runs.
+func (p *parser) categorizeByMemory(txns []Txn) []Txn {
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		m := p.memory[payeeKey(t.Desc)]
+		if t.Cur > 0 && len(m.Credit) > 0 {
+			t.From = m.Credit
+		} else if t.Cur <= 0 && len(m.Debit) > 0 {
+			t.To = m.Debit
+		} else {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		count++
+		printSummary(t, count, count)
+		p.writeToDB(t)
+	}
+	fmt.Printf("\t%d txns have been categorized based on known payees.\n\n", count)
+	return unmatched
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestRememberedPayee(t *testing.T) {
+	p, cleanup := newTestParser(t)
+	defer cleanup()
+	p.memory = make(map[string]payeeMemory)
+
+	p.rememberTxn(Txn{Desc: "TRADER JOE'S  #123", To: "Expenses:Food:Groceries",
+		From: "Assets:Bank", Cur: -40})
+	p.rememberTxn(Txn{Desc: "ACME PAYROLL", To: "Assets:Bank", From: "Income:Salary",
+		Cur: 1000})
+
+	txns := []Txn{
+		{Desc: "trader joe's #123", Key: []byte("a"), From: "Assets:Bank", Cur: -25},
+		{Desc: "ACME PAYROLL", Key: []byte("b"), To: "Assets:Bank", Cur: 1000},
+		{Desc: "ACME PAYROLL", Key: []byte("c"), From: "Assets:Bank", Cur: -5},
+		{Desc: "UNKNOWN", Key: []byte("d"), From: "Assets:Bank", Cur: -5},
+	}
+	unmatched := p.categorizeByMemory(txns)
+	if len(unmatched) != 2 {
+		t.Fatalf("got %d unmatched txns, want 2", len(unmatched))
+	}
+
+	got := make(map[string]Txn)
+	for _, txn := range p.iterateDB() {
+		got[string(txn.Key)] = txn
+	}
+	if got["a"].To != "Expenses:Food:Groceries" {
+		t.Errorf("got %q for remembered debit, want Expenses:Food:Groceries", got["a"].To)
+	}
+	if got["b"].From != "Income:Salary" {
+		t.Errorf("got %q for remembered credit, want Income:Salary", got["b"].From)
+	}
+	if _, has := got["c"]; has {
+		t.Errorf("debit from a payee only remembered for credits was categorized")
+	}
+
+	// Txns are only remembered once written out, so undoing one leaves no trace.
+	p.writeToDB(Txn{Desc: "NEW PAYEE", Key: []byte("e"), To: "Expenses:Misc", Cur: -1})
+	p.deleteFromDB([]byte("e"))
+	if _, has := p.memory[payeeKey("NEW PAYEE")]; has {
+		t.Errorf("payee of a txn written to db was remembered before being written out")
+	}
+}
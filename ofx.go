@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ofxFile = flag.String("ofx", "", "File path of OFX or QFX file containing new"+
+		" transactions.")
+	ofxSkipPending = flag.Bool("ofx-skip-pending", false, "Skip OFX txns which are marked"+
+		" as pending.")
+)
+
+var (
+	rofxTag     = regexp.MustCompile(`<([A-Z0-9.]+)>([^<\r\n]*)`)
+	rofxPending = regexp.MustCompile(`(?i)\bpending\b`)
+)
+
+// ofxFields returns the leaf elements in the block, which works for both SGML
+// (OFX 1.x), where closing tags are optional, and XML (OFX 2.x).
+func ofxFields(block string) map[string]string {
+	fields := make(map[string]string)
+	for _, m := range rofxTag.FindAllStringSubmatch(block, -1) {
+		if val := html.UnescapeString(strings.TrimSpace(m[2])); len(val) > 0 {
+			fields[m[1]] = val
+		}
+	}
+	return fields
+}
+
+// parseOFXDate parses the date out of YYYYMMDD[HHMMSS[.XXX]][[TZ]].
+func parseOFXDate(val string) (time.Time, bool) {
+	if len(val) < 8 {
+		return time.Time{}, false
+	}
+	tm, err := time.Parse("20060102", val[:8])
+	return tm, err == nil
+}
+
+// parseTransactionsFromOFX parses the STMTTRN records of the statement. FITID
+// is used as the key of the txn, so it is stable across runs. Desc is picked from
+// NAME, falling back to MEMO. A txn mentioning pending in either of these is
+// marked as pending.
+func parseTransactionsFromOFX(in []byte) []Txn {
+	data := string(in)
+	var curName string
+	if idx := strings.Index(data, "<CURDEF>"); idx >= 0 {
+		curName = ofxFields(data[idx:])["CURDEF"]
+	}
+
+	var result []Txn
+	blocks := strings.Split(data, "<STMTTRN>")
+	for i, block := range blocks {
+		if i == 0 {
+			continue // Header before the first txn.
+		}
+		if end := strings.Index(block, "</STMTTRN>"); end >= 0 {
+			block = block[:end]
+		}
+		fields := ofxFields(block)
+
+		var t Txn
+		var ok bool
+		t.Date, ok = parseOFXDate(fields["DTPOSTED"])
+		assertf(ok, "Unable to parse DTPOSTED %q of txn %d", fields["DTPOSTED"], i)
+		amt, err := strconv.ParseFloat(fields["TRNAMT"], 64)
+		checkf(err, "Unable to parse TRNAMT %q of txn %d", fields["TRNAMT"], i)
+		t.Cur = amt
+		t.CurName = curName
+
+		desc := fields["NAME"]
+		if len(desc) == 0 {
+			desc = fields["MEMO"]
+		}
+		t.Desc, _ = parseDescription(desc)
+		assertf(len(t.Desc) > 0, "Expected NAME or MEMO for txn %d", i)
+		if memo := fields["MEMO"]; len(memo) > 0 && memo != desc {
+			t.Note = memo
+		}
+
+		if rofxPending.MatchString(fields["NAME"] + " " + fields["MEMO"]) {
+			if *ofxSkipPending {
+				continue
+			}
+			t.Status = statusPending
+		}
+		t.ID = fields["FITID"]
+		if id := t.ID; len(id) > 0 && !usedKeys[id] {
+			t.Key = []byte(id)
+			usedKeys[id] = true
+		} else {
+			t.Key = newKey()
+		}
+		t.Index = len(result)
+		result = append(result, t)
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const ofxSGML = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240301120000.000[-5:EST]
+<TRNAMT>-4.50
+<FITID>SGML-1
+<NAME>STARBUCKS &amp; CO
+<MEMO>Card 1234
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240302
+<TRNAMT>-12.00
+<FITID>SGML-1
+<MEMO>PARKING METER
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240303
+<TRNAMT>-30.00
+<FITID>SGML-3
+<NAME>SHELL OIL
+<MEMO>Pending authorization
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const ofxXML = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211"?>
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>EUR</CURDEF>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20240305</DTPOSTED><TRNAMT>1000.00</TRNAMT>` +
+	`<FITID>XML-1</FITID><NAME>ACME PAYROLL</NAME></STMTTRN>
+<STMTTRN>
+  <TRNTYPE>DEBIT</TRNTYPE>
+  <DTPOSTED>20240306</DTPOSTED>
+  <TRNAMT>-8.20</TRNAMT>
+  <FITID>XML-2</FITID>
+  <MEMO>BAKERY</MEMO>
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>
+`
+
+func TestParseTransactionsFromOFX(t *testing.T) {
+	defer func(v bool) { *ofxSkipPending = v }(*ofxSkipPending)
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+
+	type want struct {
+		date   time.Time
+		desc   string
+		note   string
+		cur    float64
+		id     string
+		fitKey bool // Key is the FITID.
+		status string
+	}
+	tests := []struct {
+		name        string
+		data        string
+		skipPending bool
+		curName     string
+		want        []want
+	}{
+		{"sgml", ofxSGML, false, "USD", []want{
+			{date(1), "STARBUCKS & CO", "Card 1234", -4.5, "SGML-1", true, ""},
+			// Repeated FITID falls back to a new key. MEMO is used without NAME.
+			{date(2), "PARKING METER", "", -12, "SGML-1", false, ""},
+			{date(3), "SHELL OIL", "Pending authorization", -30, "SGML-3", true, statusPending},
+		}},
+		{"sgml skip pending", ofxSGML, true, "USD", []want{
+			// The FITIDs were already used as keys by the run above.
+			{date(1), "STARBUCKS & CO", "Card 1234", -4.5, "SGML-1", false, ""},
+			{date(2), "PARKING METER", "", -12, "SGML-1", false, ""},
+		}},
+		{"xml", ofxXML, false, "EUR", []want{
+			{date(5), "ACME PAYROLL", "", 1000, "XML-1", true, ""},
+			{date(6), "BAKERY", "", -8.2, "XML-2", true, ""},
+		}},
+	}
+	for _, tc := range tests {
+		*ofxSkipPending = tc.skipPending
+		txns := parseTransactionsFromOFX([]byte(tc.data))
+		if len(txns) != len(tc.want) {
+			t.Fatalf("%s: got %d txns, want %d", tc.name, len(txns), len(tc.want))
+		}
+		for i, w := range tc.want {
+			got := txns[i]
+			if !got.Date.Equal(w.date) || got.Desc != w.desc || got.Note != w.note ||
+				got.Cur != w.cur || got.CurName != tc.curName || got.ID != w.id ||
+				got.Status != w.status || got.Index != i {
+				t.Errorf("%s: txn %d: got %+v, want %+v", tc.name, i, got, w)
+			}
+			if isFit := string(got.Key) == w.id; isFit != w.fitKey || len(got.Key) == 0 {
+				t.Errorf("%s: txn %d: got key %q, want FITID key %v", tc.name, i, got.Key, w.fitKey)
+			}
+		}
+	}
+}
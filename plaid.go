@@ -9,15 +9,25 @@ import (
 	"net/http"
 	"path"
 	"sort"
+	"strings"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 var (
-	plaidSince = flag.String("pfrom", pstart, "YYYY-MM-DD, start date for Plaid txns.")
-	plaidTo    = flag.String("pto", pend, "YYYY-MM-DD, end date for Plaid txns.")
-	plaidHist  = flag.String("phist", "", "Use Plaid to generate a historical balance."+
+	plaidSince = flag.String("pfrom", pstart, "YYYY-MM-DD, start date for Plaid txns. Only"+
+		" used on the first import of an account. Later imports continue from where the"+
+		" last one left off.")
+	plaidTo = flag.String("pto", pend, "YYYY-MM-DD, end date for Plaid txns, with -phist."+
+		" Imports always pull up to the latest txn.")
+	plaidPending = flag.Bool("include-pending", false, "Import pending Plaid txns, marked"+
+		" as pending along with their id. Pending txns already imported, which have since"+
+		" posted, are reported.")
+	plaidAssert = flag.Bool("assert-balance", false, "Write an opening balance assertion,"+
+		" derived from the current Plaid balance, before the txns of accounts imported for"+
+		" the first time.")
+	plaidHist = flag.String("phist", "", "Use Plaid to generate a historical balance."+
 		" Use + for using balance as positive amount, - for negative amount,"+
 		" and 0 for starting with zero balance.")
 )
@@ -31,6 +41,7 @@ type PlaidTxn struct {
 	Currency  string   `json:"iso_currency_code"`
 	Desc      string   `json:"name"`
 	Pending   bool     `json:"pending"`
+	PendingId string   `json:"pending_transaction_id"`
 }
 
 type Balance struct {
@@ -63,6 +74,7 @@ type PlaidRequest struct {
 	ClientId    string            `json:"client_id" yaml:"client_id"`
 	AccessToken string            `json:"access_token" yaml:"access_token"`
 	Accounts    map[string]string `json:"-" yaml:"accounts"`
+	Environment string            `json:"-" yaml:"environment"`
 	StartDate   string            `json:"start_date"`
 	EndDate     string            `json:"end_date"`
 	Opt         PlaidOptions      `json:"options"`
@@ -70,35 +82,64 @@ type PlaidRequest struct {
 
 var plaidDate = "2006-01-02"
 
-func googleIt(preq PlaidRequest) (*PlaidResponse, error) {
+// plaidHosts maps the Plaid environment to its API host.
+var plaidHosts = map[string]string{
+	"production":  "https://production.plaid.com",
+	"development": "https://development.plaid.com",
+	"sandbox":     "https://sandbox.plaid.com",
+}
+
+// host returns the API host for the environment in plaid.yaml, which defaults to
+// production.
+func (preq PlaidRequest) host() string {
+	if len(preq.Environment) == 0 {
+		return plaidHosts["production"]
+	}
+	return plaidHosts[preq.Environment]
+}
+
+// plaidPost posts the request to the Plaid endpoint, and parses the response into
+// resp.
+func plaidPost(host, endpoint string, preq, resp interface{}) error {
+	if *offline {
+		return fmt.Errorf("Network access to plaid.com is disabled by -offline")
+	}
 	client := &http.Client{}
 	data, err := json.Marshal(preq)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if *debug {
 		fmt.Printf("Request to plaid.com: %s\n", data)
 	}
 	buf := bytes.NewBuffer(data)
-	req, err := http.NewRequest("POST", "https://development.plaid.com/transactions/get", buf)
+	req, err := http.NewRequest("POST", host+endpoint, buf)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	data, err = ioutil.ReadAll(resp.Body)
+	defer res.Body.Close()
+	data, err = ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if *debug {
 		fmt.Printf("response: %s\n", data)
 	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Plaid %s failed with %s: %s", endpoint, res.Status, data)
+	}
+	return json.Unmarshal(data, resp)
+}
+
+func googleIt(preq PlaidRequest) (*PlaidResponse, error) {
 	pp := &PlaidResponse{}
-	if err := json.Unmarshal(data, pp); err != nil {
+	if err := plaidPost(preq.host(), "/transactions/get", preq, pp); err != nil {
 		return nil, err
 	}
 	return pp, nil
@@ -208,8 +249,7 @@ func BalanceHistory(account string) error {
 	return nil
 }
 
-func newPlaidRequest(account string) (*PlaidRequest, error) {
-	configPath := path.Join(*configDir, "plaid.yaml")
+func loadPlaidConfig(configPath string) (*PlaidRequest, error) {
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return nil, err
@@ -220,7 +260,21 @@ func newPlaidRequest(account string) (*PlaidRequest, error) {
 	}
 
 	preq := &PlaidRequest{}
-	checkf(yaml.Unmarshal(data, preq), "Unable to parse plaid.yaml at %s", configPath)
+	if err := yaml.Unmarshal(data, preq); err != nil {
+		return nil, fmt.Errorf("Unable to parse plaid.yaml at %s: %v", configPath, err)
+	}
+	if _, has := plaidHosts[preq.Environment]; len(preq.Environment) > 0 && !has {
+		return nil, fmt.Errorf("Invalid environment %q in %s. Expected one of: production,"+
+			" sandbox, development", preq.Environment, configPath)
+	}
+	return preq, nil
+}
+
+func newPlaidRequest(account string) (*PlaidRequest, error) {
+	preq, err := loadPlaidConfig(path.Join(*configDir, "plaid.yaml"))
+	if err != nil {
+		return nil, err
+	}
 	preq.StartDate = *plaidSince
 	preq.EndDate = *plaidTo
 
@@ -231,68 +285,345 @@ func newPlaidRequest(account string) (*PlaidRequest, error) {
 		}
 	}
 	if len(accountId) == 0 {
-		return nil, fmt.Errorf("No account %q was found in config\n", accountId)
+		return nil, fmt.Errorf("No account %q was found in config\n", account)
 	}
 	preq.Opt.AccountIds = []string{accountId}
 	preq.Opt.Count = 500
 	return preq, nil
 }
 
-func GetPlaidTransactions(account string) ([]Txn, error) {
+type PlaidSyncOptions struct {
+	AccountId string `json:"account_id,omitempty"`
+}
+
+type PlaidSyncRequest struct {
+	Secret      string           `json:"secret"`
+	ClientId    string           `json:"client_id"`
+	AccessToken string           `json:"access_token"`
+	Cursor      string           `json:"cursor,omitempty"`
+	Count       int              `json:"count"`
+	Opt         PlaidSyncOptions `json:"options"`
+}
+
+type PlaidSyncResponse struct {
+	Accounts []PlaidAccount `json:"accounts"`
+	Added    []PlaidTxn     `json:"added"`
+	Modified []PlaidTxn     `json:"modified"`
+	Removed  []struct {
+		Id string `json:"transaction_id"`
+	} `json:"removed"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// plaidCursors stores the /transactions/sync cursor per access token and account,
+// in plaid_cursors.yaml in conf dir.
+type plaidCursors map[string]string
+
+func cursorKey(preq *PlaidRequest, accountId string) string {
+	return preq.AccessToken + "/" + accountId
+}
+
+func cursorsPath() string {
+	return path.Join(*configDir, "plaid_cursors.yaml")
+}
+
+func loadCursors() (plaidCursors, error) {
+	cursors := make(plaidCursors)
+	data, err := ioutil.ReadFile(cursorsPath())
+	if err != nil {
+		return cursors, nil
+	}
+	if err := yaml.Unmarshal(data, &cursors); err != nil {
+		return nil, fmt.Errorf("Unable to parse plaid cursors at %s: %v", cursorsPath(), err)
+	}
+	return cursors, nil
+}
+
+// pendingCursors are the cursors reached by GetPlaidTransactions. They're only
+// saved via savePlaidCursors, once the txns have been written out, so a failed run
+// would fetch the same txns again.
+var pendingCursors plaidCursors
+
+func savePlaidCursors() error {
+	if len(pendingCursors) == 0 {
+		return nil
+	}
+	cursors, err := loadCursors()
+	if err != nil {
+		return err
+	}
+	for k, v := range pendingCursors {
+		cursors[k] = v
+	}
+	data, err := yaml.Marshal(cursors)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cursorsPath(), data, 0600)
+}
+
+// plaidAccounts returns the short names of the accounts to import, given a comma
+// separated list of them, or all.
+func plaidAccounts(spec string) ([]string, error) {
+	if spec != "all" {
+		var accounts []string
+		for _, account := range strings.Split(spec, ",") {
+			accounts = append(accounts, strings.TrimSpace(account))
+		}
+		return accounts, nil
+	}
+	preq, err := loadPlaidConfig(path.Join(*configDir, "plaid.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var accounts []string
+	for short := range preq.Accounts {
+		accounts = append(accounts, short)
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// newBalances holds the current balance of the accounts imported for the first
+// time, as reported by Plaid.
+var newBalances = make(map[string]float64)
+
+// balanceAssertions returns an opening balance assertion per account imported for
+// the first time, to be written before the imported txns. The opening balance is
+// the current balance, less the posted txns imported for the account. It's
+// asserted as of the day before the first of those txns.
+func balanceAssertions(balances map[string]float64, txns []Txn, curName string) string {
+	var accounts []string
+	for account := range balances {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	var b bytes.Buffer
+	for _, account := range accounts {
+		bal := balances[account]
+		first := time.Now()
+		for _, t := range txns {
+			src := t.From
+			if t.Cur > 0 {
+				src = t.To
+			}
+			if src != account || t.Status == statusPending {
+				// Plaid's current balance excludes pending txns.
+				continue
+			}
+			bal -= t.Cur
+			if t.Date.Before(first) {
+				first = t.Date
+			}
+		}
+		date := first.AddDate(0, 0, -1)
+		if *outFormat == "beancount" {
+			b.WriteString(beancountBalance(date, account, bal, curName))
+			continue
+		}
+		var sign string
+		if bal < 0 {
+			sign = "-"
+		}
+		zero := formatAmount(Txn{CurName: curName})
+		amt := formatAmount(Txn{Cur: bal, CurName: curName})
+		b.WriteString(fmt.Sprintf("%s * Opening balance assertion\n", date.Format(stamp)))
+		b.WriteString(fmt.Sprintf("\t%-20s\t%s = %s%s\n\n", account, zero, sign, amt))
+	}
+	return b.String()
+}
+
+// GetPlaidTransactions pulls the txns for every account in the comma separated
+// list, or all of the accounts in plaid.yaml. With more than one account, each txn
+// is tagged with the account it came from.
+func GetPlaidTransactions(spec string) ([]Txn, error) {
+	accounts, err := plaidAccounts(spec)
+	if err != nil {
+		return nil, err
+	}
+	pendingCursors = make(plaidCursors)
+	var txns []Txn
+	for _, account := range accounts {
+		account = strings.TrimSpace(account)
+		fmt.Printf("Pulling txns for account: %s\n", account)
+		atxns, err := getPlaidAccountTxns(account)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range atxns {
+			if len(accounts) > 1 {
+				t.Account = account
+			}
+			t.Index = len(txns)
+			txns = append(txns, t)
+		}
+	}
+	return txns, nil
+}
+
+// getPlaidAccountTxns uses /transactions/sync to pull the txns added since the last
+// run. On the first run, only txns since -pfrom are retained. Txns modified or
+// removed by Plaid were imported by an earlier run. So, they're reported, to be
+// reconciled by hand.
+func getPlaidAccountTxns(account string) ([]Txn, error) {
 	preq, err := newPlaidRequest(account)
 	if err != nil {
 		return nil, err
 	}
 	accountId := preq.Opt.AccountIds[0]
 
-	var gotTxns int
+	cursors, err := loadCursors()
+	if err != nil {
+		return nil, err
+	}
+	key := cursorKey(preq, accountId)
+	sreq := PlaidSyncRequest{
+		Secret:      preq.Secret,
+		ClientId:    preq.ClientId,
+		AccessToken: preq.AccessToken,
+		Cursor:      cursors[key],
+		Count:       500,
+		Opt:         PlaidSyncOptions{AccountId: accountId},
+	}
+	initial := len(sreq.Cursor) == 0
+	var since string
+	if initial {
+		since = preq.StartDate
+	}
+
 	var txns []Txn
+	var modified, removed []string
+	var found bool
 	for {
-		pp, err := googleIt(*preq)
-		if err != nil {
+		var sp PlaidSyncResponse
+		if err := plaidPost(preq.host(), "/transactions/sync", sreq, &sp); err != nil {
 			return nil, err
 		}
-
-		var found bool
-		for _, a := range pp.Accounts {
-			if a.Id == accountId {
+		for _, a := range sp.Accounts {
+			if a.Id == accountId && !found {
 				fmt.Printf("Found account %+v\n", a)
 				fmt.Printf("Balance: %+v\n", a.Bal)
 				found = true
+				if initial {
+					bal := a.Bal.Current
+					if strings.Contains(a.Type, "credit") {
+						// Plaid reports the amount owed as positive.
+						bal = -bal
+					}
+					newBalances[account] = bal
+				}
 			}
 		}
-		if !found {
-			return nil, fmt.Errorf("Unable to find any account with id: %q", accountId)
-		}
 
-		fmt.Println()
-		for _, txn := range pp.Txns {
-			if txn.Pending || txn.AccountId != accountId {
-				continue
-			}
-			tm, err := time.Parse(plaidDate, txn.Date)
-			if err != nil {
-				return nil, err
-			}
-			t := Txn{
-				Date:    tm,
-				Desc:    txn.Desc,
-				Cur:     -txn.Amount, // Negative because of how Ledger works.
-				CurName: txn.Currency,
-				Key:     []byte(txn.Id),
-			}
-			txns = append(txns, t)
-			if *debug {
-				fmt.Printf("Txn: %+v\n", txn)
+		atxns, err := fromPlaid(sp.Added, accountId, since)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, atxns...)
+		for _, txn := range sp.Modified {
+			if txn.AccountId == accountId {
+				modified = append(modified, txn.Id)
 			}
 		}
-		gotTxns += len(pp.Txns)
-		fmt.Printf("Txns retrieved: %d. Total: %d.\n", gotTxns, pp.Total)
-		if gotTxns < pp.Total {
-			preq.Opt.Offset = gotTxns
-		} else {
+		for _, r := range sp.Removed {
+			removed = append(removed, r.Id)
+		}
+		fmt.Printf("Txns added: %d. Modified: %d. Removed: %d.\n",
+			len(sp.Added), len(sp.Modified), len(sp.Removed))
+
+		sreq.Cursor = sp.NextCursor
+		if !sp.HasMore {
 			break
 		}
 	}
+	if !found {
+		return nil, fmt.Errorf("Unable to find any account with id: %q", accountId)
+	}
+	pendingCursors[key] = sreq.Cursor
+
+	final, posted := dropPosted(txns)
+	for _, id := range modified {
+		fmt.Printf("Txn modified by Plaid, reconcile by hand if already imported: %s\n", id)
+	}
+	for _, id := range removed {
+		if !posted[id] {
+			fmt.Printf("Txn removed by Plaid, reconcile by hand if already imported: %s\n", id)
+		}
+	}
+	return final, nil
+}
+
+// fromPlaid converts the Plaid txns of the account. Pending txns are skipped,
+// unless -include-pending is set. If since is set, older txns are skipped too.
+func fromPlaid(ptxns []PlaidTxn, accountId, since string) ([]Txn, error) {
+	var txns []Txn
+	for _, txn := range ptxns {
+		if txn.AccountId != accountId {
+			continue
+		}
+		if txn.Pending && !*plaidPending {
+			continue
+		}
+		if len(since) > 0 && txn.Date < since {
+			continue
+		}
+		tm, err := time.Parse(plaidDate, txn.Date)
+		if err != nil {
+			return nil, err
+		}
+		t := Txn{
+			Date:    tm,
+			Desc:    txn.Desc,
+			Cur:     -txn.Amount, // Negative because of how Ledger works.
+			CurName: txn.Currency,
+			Key:     []byte(txn.Id),
+			ID:      txn.Id,
+
+			PlaidCategory: strings.Join(txn.Category, " > "),
+			PendingID:     txn.PendingId,
+		}
+		if txn.Pending {
+			t.Status = statusPending
+		}
+		txns = append(txns, t)
+		if *debug {
+			fmt.Printf("Txn: %+v\n", txn)
+		}
+	}
 	return txns, nil
 }
+
+// dropPosted drops the pending txns which have been replaced by their posted txns
+// in the same fetch. It also returns the ids of all the pending txns which have
+// posted. Pending txns imported by an earlier run are found by stalePending.
+func dropPosted(txns []Txn) ([]Txn, map[string]bool) {
+	posted := make(map[string]bool)
+	for _, t := range txns {
+		if len(t.PendingID) > 0 {
+			posted[t.PendingID] = true
+		}
+	}
+	final := txns[:0]
+	for _, t := range txns {
+		if t.Status == statusPending && posted[t.ID] {
+			continue
+		}
+		final = append(final, t)
+	}
+	return final, posted
+}
+
+// stalePending returns the txns which post a pending txn already present in the
+// journal or output file, as per their ids. The entries of those pending txns must
+// be removed by hand, as the posted txns are imported anew.
+func stalePending(txns []Txn, ids map[string]bool) []Txn {
+	var stale []Txn
+	for _, t := range txns {
+		if len(t.PendingID) > 0 && ids[t.PendingID] {
+			stale = append(stale, t)
+		}
+	}
+	return stale
+}
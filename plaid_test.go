@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromPlaidPending(t *testing.T) {
+	ptxns := []PlaidTxn{
+		{Id: "p1", AccountId: "acc", Amount: 12.5, Date: "2024-03-02", Desc: "COFFEE", Pending: true},
+		{Id: "t1", AccountId: "acc", Amount: 40, Date: "2024-03-01", Desc: "GROCERY"},
+		{Id: "t2", AccountId: "other", Amount: 10, Date: "2024-03-01", Desc: "OTHER"},
+	}
+	defer func(v bool) { *plaidPending = v }(*plaidPending)
+
+	tests := []struct {
+		pending bool
+		ids     []string
+	}{
+		{false, []string{"t1"}},
+		{true, []string{"p1", "t1"}},
+	}
+	for _, tc := range tests {
+		*plaidPending = tc.pending
+		txns, err := fromPlaid(ptxns, "acc", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, txn := range txns {
+			ids = append(ids, txn.ID)
+			if want := txn.ID == "p1"; (txn.Status == statusPending) != want {
+				t.Errorf("pending=%v: txn %s has status %q", tc.pending, txn.ID, txn.Status)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+			t.Errorf("pending=%v: got txns %v, want %v", tc.pending, ids, tc.ids)
+		}
+	}
+}
+
+func TestFromPlaidSince(t *testing.T) {
+	ptxns := []PlaidTxn{
+		{Id: "t1", AccountId: "acc", Amount: 40, Date: "2024-02-28"},
+		{Id: "t2", AccountId: "acc", Amount: 10, Date: "2024-03-01"},
+	}
+	txns, err := fromPlaid(ptxns, "acc", "2024-03-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].ID != "t2" {
+		t.Errorf("got %+v, want only t2", txns)
+	}
+	if txns[0].Cur != -10 {
+		t.Errorf("got amount %v, want -10", txns[0].Cur)
+	}
+}
+
+func TestDropPosted(t *testing.T) {
+	txns := []Txn{
+		{ID: "p1", Status: statusPending},
+		{ID: "t1", PendingID: "p1"},
+		{ID: "t2", PendingID: "p0"},
+		{ID: "p3", Status: statusPending},
+	}
+	final, posted := dropPosted(txns)
+	var ids []string
+	for _, txn := range final {
+		ids = append(ids, txn.ID)
+	}
+	if got := strings.Join(ids, ","); got != "t1,t2,p3" {
+		t.Errorf("got txns %s, want t1,t2,p3", got)
+	}
+	if !posted["p1"] || !posted["p0"] || posted["p3"] {
+		t.Errorf("got posted %v, want p0 and p1", posted)
+	}
+}
+
+func TestStalePending(t *testing.T) {
+	txns := []Txn{
+		{ID: "t1", PendingID: "p1"},
+		{ID: "t2", PendingID: "p2"},
+		{ID: "t3"},
+	}
+	ids := map[string]bool{"p1": true, "t3": true}
+	stale := stalePending(txns, ids)
+	if len(stale) != 1 || stale[0].ID != "t1" {
+		t.Errorf("got %+v, want only t1", stale)
+	}
+}
+
+func TestLedgerFormatPending(t *testing.T) {
+	txn := Txn{ID: "p1", Status: statusPending, Desc: "COFFEE", To: "Expenses:Coffee",
+		From: "Assets:Bank", Cur: -12.5, CurName: "$"}
+	out := ledgerFormat(txn)
+	for _, want := range []string{" !\tCOFFEE", "; :pending:", "; id: p1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got %q, want it to contain %q", out, want)
+		}
+	}
+}
+
+func TestBalanceAssertions(t *testing.T) {
+	defer func(f string) { *outFormat = f }(*outFormat)
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	balances := map[string]float64{"Assets:Checking": 1000}
+	txns := []Txn{
+		{Date: date(5), From: "Assets:Checking", To: "Expenses:Food", Cur: -50},
+		{Date: date(3), From: "Income:Salary", To: "Assets:Checking", Cur: 200},
+		{Date: date(2), From: "Assets:Checking", To: "Expenses:Food", Cur: -30,
+			Status: statusPending},
+		{Date: date(1), From: "Assets:Savings", To: "Expenses:Food", Cur: -10},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"ledger", "2024/03/02 * Opening balance assertion\n" +
+			"\tAssets:Checking     \t0.00USD = 850.00USD\n\n"},
+		{"beancount", "2024-03-03 balance Assets:Checking                          850.00 USD\n\n"},
+	}
+	for _, tc := range tests {
+		*outFormat = tc.format
+		if got := balanceAssertions(balances, txns, "USD"); got != tc.want {
+			t.Errorf("%s: got:\n%q\nwant:\n%q", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestPlaidPostOffline(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(`{"accounts": []}`))
+	}))
+	defer srv.Close()
+	defer func(v bool) { *offline = v }(*offline)
+
+	tests := []struct {
+		offline bool
+		hits    int
+	}{
+		{true, 0},
+		{false, 1},
+		{true, 1},
+	}
+	for _, tc := range tests {
+		*offline = tc.offline
+		var resp PlaidResponse
+		err := plaidPost(srv.URL, "/accounts/get", PlaidRequest{}, &resp)
+		if (err != nil) != tc.offline {
+			t.Errorf("offline=%v: got error %v", tc.offline, err)
+		}
+		if hits != tc.hits {
+			t.Errorf("offline=%v: got %d requests, want %d", tc.offline, hits, tc.hits)
+		}
+	}
+}
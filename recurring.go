@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	useRecurring = flag.Bool("recurring", false, "Auto-categorize txns which match a"+
+		" recurring pattern, e.g. monthly subscriptions, detected in the journal.")
+	showRecurring = flag.Bool("show-recurring", false, "Report recurring patterns detected"+
+		" in the journal, and exit.")
+)
+
+const (
+	minRecurrences = 3
+	// Amounts within this fraction of each other are considered similar.
+	amountTolerance = 0.05
+	// Gaps within this fraction of the period are considered regular.
+	periodTolerance = 0.25
+)
+
+type recurrence struct {
+	key      string
+	desc     string
+	amount   float64
+	period   time.Duration
+	category string
+	dates    []time.Time
+}
+
+func recurringKey(desc string) string {
+	return strings.ToLower(lettersOnly.ReplaceAllString(desc, ""))
+}
+
+func similarAmount(a, b float64) bool {
+	a, b = math.Abs(a), math.Abs(b)
+	return math.Abs(a-b) <= amountTolerance*math.Max(a, b)
+}
+
+// detectRecurring finds txns in the journal, which recur at regular intervals
+// with similar amounts, and are always booked against the same category.
+func (p *parser) detectRecurring() []recurrence {
+	groups := make(map[string][]Txn)
+	for _, t := range p.txns {
+		if t.skipClassification {
+			continue
+		}
+		key := recurringKey(t.Desc)
+		if len(key) == 0 {
+			continue
+		}
+		groups[key] = append(groups[key], t)
+	}
+
+	var result []recurrence
+	for key, txns := range groups {
+		if len(txns) < minRecurrences {
+			continue
+		}
+		sort.Sort(byTime(txns))
+		r := recurrence{key: key, desc: txns[0].Desc, amount: math.Abs(txns[0].Cur),
+			category: txns[0].To}
+		ok := true
+		for _, t := range txns {
+			if t.To != r.category || !similarAmount(t.Cur, r.amount) {
+				ok = false
+				break
+			}
+			r.dates = append(r.dates, t.Date)
+		}
+		if !ok {
+			continue
+		}
+
+		var gaps []time.Duration
+		for i := 1; i < len(r.dates); i++ {
+			gaps = append(gaps, r.dates[i].Sub(r.dates[i-1]))
+		}
+		sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+		r.period = gaps[len(gaps)/2]
+		if r.period < 24*time.Hour {
+			continue
+		}
+		for _, gap := range gaps {
+			if math.Abs(float64(gap-r.period)) > periodTolerance*float64(r.period) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result = append(result, r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].key < result[j].key })
+	return result
+}
+
+func (p *parser) printRecurring(recs []recurrence) {
+	fmt.Printf("Found %d recurring patterns:\n", len(recs))
+	for _, r := range recs {
+		fmt.Printf("%-40s %9.2f every %3d days, %2d times, last %s -> %s\n", r.desc, r.amount,
+			int(r.period.Hours()/24), len(r.dates), r.dates[len(r.dates)-1].Format(stamp),
+			r.category)
+	}
+}
+
+// categorizeByRecurring auto-categorizes txns, which match a recurring pattern,
+// and are due around the time expected by the pattern.
+func (p *parser) categorizeByRecurring(txns []Txn) []Txn {
+	byKey := make(map[string]recurrence)
+	for _, r := range p.detectRecurring() {
+		byKey[r.key] = r
+	}
+
+	matches := func(t Txn) (recurrence, bool) {
+		r, has := byKey[recurringKey(t.Desc)]
+		if !has || !similarAmount(t.Cur, r.amount) {
+			return r, false
+		}
+		last := r.dates[len(r.dates)-1]
+		since := t.Date.Sub(last)
+		if since <= 0 {
+			return r, false
+		}
+		// The txn should fall close to a multiple of the period after the last one.
+		periods := math.Max(1, math.Round(float64(since)/float64(r.period)))
+		off := math.Abs(float64(since) - periods*float64(r.period))
+		return r, off <= periodTolerance*float64(r.period)
+	}
+
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		r, ok := matches(t)
+		if !ok {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		if t.Cur > 0 {
+			t.From = r.category
+		} else {
+			t.To = r.category
+		}
+		count++
+		printSummary(t, count, count)
+		p.writeToDB(t)
+	}
+	fmt.Printf("\t%d txns have been categorized as recurring.\n\n", count)
+	return unmatched
+}
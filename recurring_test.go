@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// monthly returns n txns for the desc, a month apart starting from Jan 2024.
+func monthly(desc, category string, amt float64, n int) []Txn {
+	var txns []Txn
+	for i := 0; i < n; i++ {
+		txns = append(txns, Txn{Date: time.Date(2024, time.Month(1+i), 5, 0, 0, 0, 0, time.UTC),
+			Desc: desc, To: category, Cur: amt})
+	}
+	return txns
+}
+
+func TestDetectRecurring(t *testing.T) {
+	var txns []Txn
+	txns = append(txns, monthly("NETFLIX.COM 123", "Expenses:Subscriptions", 15.49, 4)...)
+	txns = append(txns, monthly("SPOTIFY", "Expenses:Subscriptions", 9.99, 2)...)
+	gym := monthly("GYM", "Expenses:Health", 50, 4)
+	gym[2].Cur = 80
+	txns = append(txns, gym...)
+	rent := monthly("RENT", "Expenses:Home", 2000, 4)
+	rent[3].To = "Expenses:Misc"
+	txns = append(txns, rent...)
+	irregular := monthly("DENTIST", "Expenses:Health", 120, 4)
+	irregular[3].Date = irregular[3].Date.AddDate(0, 3, 0)
+	txns = append(txns, irregular...)
+	phone := monthly("PHONE BILL", "Expenses:Utilities", 40, 3)
+	phone[1].Cur = 41
+	txns = append(txns, phone...)
+
+	p := parser{txns: txns}
+	recs := p.detectRecurring()
+	var got []string
+	for _, r := range recs {
+		got = append(got, r.key)
+		if days := int(r.period.Hours() / 24); days < 28 || days > 31 {
+			t.Errorf("%s: got period of %d days, want a month", r.key, days)
+		}
+	}
+	if strings.Join(got, ",") != "netflixcom,phonebill" {
+		t.Errorf("got recurring %v, want netflixcom and phonebill", got)
+	}
+}
+
+func TestCategorizeByRecurring(t *testing.T) {
+	p, cleanup := newTestParser(t)
+	defer cleanup()
+	p.txns = monthly("NETFLIX", "Expenses:Subscriptions", -15.49, 4)
+	last := p.txns[3].Date
+
+	tests := []struct {
+		date time.Time
+		amt  float64
+		want bool
+	}{
+		{last.AddDate(0, 1, 0), -15.49, true},
+		{last.AddDate(0, 1, 3), -15.49, true},
+		{last.AddDate(0, 2, 0), -15.99, true},
+		{last.AddDate(0, 1, 14), -15.49, false},
+		{last.AddDate(0, 1, 0), -25, false},
+		{last.AddDate(0, -1, 0), -15.49, false},
+	}
+	for _, tc := range tests {
+		txn := Txn{Key: newKey(), Date: tc.date, Desc: "NETFLIX", Cur: tc.amt}
+		unmatched := p.categorizeByRecurring([]Txn{txn})
+		if got := len(unmatched) == 0; got != tc.want {
+			t.Errorf("%s %.2f: got recurring %v, want %v", tc.date.Format(stamp), tc.amt, got, tc.want)
+		}
+	}
+	for _, txn := range p.iterateDB() {
+		if txn.To != "Expenses:Subscriptions" {
+			t.Errorf("%s: got category %q", txn.Date.Format(stamp), txn.To)
+		}
+	}
+}
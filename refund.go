@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	detectRefunds = flag.Bool("detect-refunds", false, "Match incoming credits against"+
+		" prior charges from the same payee, of the same or a larger amount, and book them"+
+		" against the expense of the charge.")
+	refundWithin = flag.Int("refund-within", 90, "Only match refunds against charges up to"+
+		" N days older.")
+)
+
+var rrefund = regexp.MustCompile(`(?i)\b(refund|return|reversal|credit)\b`)
+
+func refundKey(desc string) string {
+	return recurringKey(rrefund.ReplaceAllString(desc, ""))
+}
+
+// matchRefund returns the position of the charge which the credit offsets, or -1.
+// The charge must be from the same payee, not older than within, and at least as
+// large as the credit. A charge of the same amount is preferred. Otherwise, the
+// credit is a partial refund of the most recent larger charge. The amounts of the
+// charges are absolute, and the charges are sorted by time, most recent first.
+func matchRefund(t Txn, charges []Txn, within time.Duration) int {
+	partial := -1
+	key := refundKey(t.Desc)
+	for i, c := range charges {
+		if c.Date.After(t.Date) || t.Date.Sub(c.Date) > within || refundKey(c.Desc) != key {
+			continue
+		}
+		if math.Abs(c.Cur-t.Cur) < 0.005 {
+			return i
+		}
+		if partial < 0 && c.Cur > t.Cur {
+			partial = i
+		}
+	}
+	return partial
+}
+
+// categorizeRefunds offers to book a credit, which offsets a prior charge, against
+// the same account as the charge. Charges are picked from the journal, and from
+// the imported txns which have already been categorized. Partial refunds reduce
+// the amount left to be refunded of the charge.
+func (p *parser) categorizeRefunds(txns []Txn) []Txn {
+	var charges []Txn
+	for _, t := range p.txns {
+		if !t.skipClassification && t.Cur > 0 {
+			charges = append(charges, t)
+		}
+	}
+	for _, t := range p.iterateDB() {
+		if t.Cur < 0 && len(t.To) > 0 {
+			t.Cur = -t.Cur
+			charges = append(charges, t)
+		}
+	}
+	// Prefer the most recent charge.
+	sort.Sort(sort.Reverse(byTime(charges)))
+	within := time.Duration(*refundWithin) * 24 * time.Hour
+
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		idx := -1
+		if t.Cur > 0 {
+			idx = matchRefund(t, charges, within)
+		}
+		if idx < 0 {
+			unmatched = append(unmatched, t)
+			continue
+		}
+
+		c := &charges[idx]
+		fmt.Println()
+		printSummary(t, 1, 1)
+		kind := "Offsets"
+		if t.Cur < c.Cur-0.005 {
+			kind = "Partially offsets"
+		}
+		fmt.Printf("\t%s charge of %.2f on %s to %s: %s\n",
+			kind, c.Cur, c.Date.Format(stamp), c.To, c.Desc)
+		fmt.Printf("\tBook as refund (Y/n)? ")
+		key, ok := readKey()
+		fmt.Println()
+		if !ok || key == 'n' {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		t.From = c.To
+		p.writeToDB(t)
+		if c.Cur -= t.Cur; c.Cur < 0.005 {
+			charges = append(charges[:idx], charges[idx+1:]...)
+		}
+		count++
+	}
+	fmt.Printf("\t%d txns have been booked as refunds.\n\n", count)
+	return unmatched
+}
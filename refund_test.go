@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchRefund(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	// Sorted by time, most recent first, as categorizeRefunds does.
+	charges := []Txn{
+		{Date: date(20), Desc: "AMAZON MKTPLACE", To: "Expenses:Home", Cur: 80},
+		{Date: date(10), Desc: "AMAZON MKTPLACE", To: "Expenses:Books", Cur: 30},
+		{Date: date(5), Desc: "BEST BUY 123", To: "Expenses:Electronics", Cur: 200},
+	}
+	within := 30 * 24 * time.Hour
+
+	tests := []struct {
+		name string
+		txn  Txn
+		want int
+	}{
+		{"exact amount", Txn{Date: date(25), Desc: "AMAZON MKTPLACE REFUND", Cur: 30}, 1},
+		{"partial refund", Txn{Date: date(25), Desc: "AMAZON MKTPLACE", Cur: 20}, 0},
+		{"partial of older charge", Txn{Date: date(15), Desc: "AMAZON MKTPLACE", Cur: 20}, 1},
+		{"larger than charges", Txn{Date: date(25), Desc: "AMAZON MKTPLACE", Cur: 100}, -1},
+		{"other payee", Txn{Date: date(25), Desc: "TARGET", Cur: 30}, -1},
+		{"before charge", Txn{Date: date(4), Desc: "BEST BUY 123", Cur: 200}, -1},
+		{"outside window", Txn{Date: date(5).AddDate(0, 2, 0), Desc: "BEST BUY 123", Cur: 50}, -1},
+		{"within window", Txn{Date: date(30), Desc: "BEST BUY 123 RETURN", Cur: 50}, 2},
+	}
+	for _, tc := range tests {
+		if got := matchRefund(tc.txn, charges, within); got != tc.want {
+			t.Errorf("%s: got charge %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var matchReimburse = flag.Bool("match-reimbursements", false, "Match incoming payments"+
+	" against outstanding expenses in Assets:Reimbursements: accounts.")
+
+const reimbursePrefix = "Assets:Reimbursements:"
+
+// outstandingReimbursements returns the txns booked against reimbursement
+// accounts, which haven't been paid back yet. A payment is considered to clear an
+// expense in the same account with the same amount.
+func (p *parser) outstandingReimbursements() []Txn {
+	var owed []Txn
+	paid := make(map[string][]float64)
+	for _, t := range p.txns {
+		if !strings.HasPrefix(t.To, reimbursePrefix) {
+			continue
+		}
+		if t.Cur > 0 {
+			owed = append(owed, t)
+		} else {
+			paid[t.To] = append(paid[t.To], math.Abs(t.Cur))
+		}
+	}
+	sort.Sort(byTime(owed))
+
+	final := owed[:0]
+	for _, t := range owed {
+		amts := paid[t.To]
+		var cleared bool
+		for i, amt := range amts {
+			if amt == t.Cur {
+				paid[t.To] = append(amts[:i], amts[i+1:]...)
+				cleared = true
+				break
+			}
+		}
+		if !cleared {
+			final = append(final, t)
+		}
+	}
+	return final
+}
+
+// matchOwed returns the index of the earliest outstanding expense, which the
+// incoming payment pays back, or -1.
+func matchOwed(t Txn, owed []Txn) int {
+	if t.Cur <= 0 {
+		return -1
+	}
+	for i, o := range owed {
+		if o.Cur == t.Cur && !o.Date.After(t.Date) {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchReimbursements offers to categorize incoming payments, which match the
+// amount of an outstanding reimbursable expense, against its reimbursement
+// account.
+func (p *parser) matchReimbursements(txns []Txn) []Txn {
+	owed := p.outstandingReimbursements()
+	if len(owed) == 0 {
+		return txns
+	}
+
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		idx := matchOwed(t, owed)
+		if idx < 0 {
+			unmatched = append(unmatched, t)
+			continue
+		}
+
+		o := owed[idx]
+		fmt.Println()
+		printSummary(t, 1, 1)
+		fmt.Printf("\tMatches outstanding %s of %.2f on %s: %s\n",
+			o.To, o.Cur, o.Date.Format(stamp), o.Desc)
+		fmt.Printf("\tClear it (Y/n)? ")
+		key, ok := readKey()
+		fmt.Println()
+		if !ok || key == 'n' {
+			unmatched = append(unmatched, t)
+			continue
+		}
+		t.From = o.To
+		p.writeToDB(t)
+		owed = append(owed[:idx], owed[idx+1:]...)
+		count++
+	}
+	fmt.Printf("\t%d txns have been matched against reimbursements.\n\n", count)
+	return unmatched
+}
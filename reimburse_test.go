@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutstandingReimbursements(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	work := reimbursePrefix + "Work"
+	p := parser{txns: []Txn{
+		{Date: date(5), Desc: "HOTEL", To: work, Cur: 200},
+		{Date: date(1), Desc: "FLIGHT", To: work, Cur: 300},
+		{Date: date(2), Desc: "DINNER", To: reimbursePrefix + "Friend", Cur: 40},
+		{Date: date(10), Desc: "PAYROLL", To: work, Cur: -300},
+		{Date: date(3), Desc: "COFFEE", To: "Expenses:Coffee", Cur: 4},
+	}}
+	owed := p.outstandingReimbursements()
+
+	tests := []struct {
+		payment Txn
+		want    string
+	}{
+		{Txn{Date: date(20), Cur: 200}, "HOTEL"},
+		{Txn{Date: date(20), Cur: 40}, "DINNER"},
+		// Already paid back.
+		{Txn{Date: date(20), Cur: 300}, ""},
+		// Paid before the expense.
+		{Txn{Date: date(4), Cur: 200}, ""},
+		{Txn{Date: date(20), Cur: -200}, ""},
+		{Txn{Date: date(20), Cur: 4}, ""},
+	}
+	for _, tc := range tests {
+		var got string
+		if idx := matchOwed(tc.payment, owed); idx >= 0 {
+			got = owed[idx].Desc
+		}
+		if got != tc.want {
+			t.Errorf("%+v: got match %q, want %q", tc.payment, got, tc.want)
+		}
+	}
+}
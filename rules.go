@@ -0,0 +1,364 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	checkRules   = flag.Bool("check-rules", false, "Validate rules.yaml in conf dir, and exit.")
+	explainRules = flag.Bool("explain-rules", false, "Report txns which match rules of more"+
+		" than one category.")
+	testRules = flag.Bool("test-rules", false, "Report how many txns in the journal each rule"+
+		" matches, along with a sample of them, and exit.")
+)
+
+type rule struct {
+	category string
+	pattern  *regexp.Regexp
+	debit    string // If set, used instead of category for debits.
+	credit   string // If set, used instead of category for credits.
+	weekdays map[time.Weekday]bool
+	payee    string // If set, replaces the description.
+	currency string // If set, replaces the currency.
+	priority int    // Rules with higher priority are evaluated first.
+}
+
+// ruleConf is the condition object form of a rule.
+type ruleConf struct {
+	Match    string   `yaml:"match"`
+	Debit    string   `yaml:"debit"`
+	Credit   string   `yaml:"credit"`
+	Weekdays []string `yaml:"weekdays"`
+	To       string   `yaml:"to"`
+	Payee    string   `yaml:"payee"`
+	Currency string   `yaml:"currency"`
+	Priority int      `yaml:"priority"`
+}
+
+// matches returns true if the txn satisfies all the conditions of the rule.
+func (r rule) matches(t Txn) bool {
+	if len(r.weekdays) > 0 && !r.weekdays[t.Date.Weekday()] {
+		return false
+	}
+	return r.pattern == nil || r.pattern.MatchString(t.Desc)
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		if day == name || day == name[:3] {
+			return wd, true
+		}
+	}
+	return 0, false
+}
+
+// accountFor returns the account the rule assigns to the txn.
+func (r rule) accountFor(t Txn) string {
+	if t.Cur > 0 && len(r.credit) > 0 {
+		return r.credit
+	}
+	if t.Cur <= 0 && len(r.debit) > 0 {
+		return r.debit
+	}
+	return r.category
+}
+
+// parseRule parses an entry in the list of a category, which is either a
+// pattern, or a condition object.
+func parseRule(category string, v interface{}) (rule, error) {
+	var rc ruleConf
+	if pattern, ok := v.(string); ok {
+		rc.Match = pattern
+	} else {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return rule{}, err
+		}
+		if err := yaml.UnmarshalStrict(data, &rc); err != nil {
+			return rule{}, fmt.Errorf("Invalid rule for category %s: %v", category, err)
+		}
+	}
+	if len(rc.To) > 0 {
+		// The category comes from the key. Only the list form specifies to.
+		return rule{}, fmt.Errorf("Unexpected to %q in rule for category %s", rc.To, category)
+	}
+	return newRule(category, rc)
+}
+
+// newRule compiles the condition object into a rule for the category.
+func newRule(category string, rc ruleConf) (rule, error) {
+	r := rule{category: category}
+	if len(rc.Match) == 0 && len(rc.Weekdays) == 0 {
+		return r, fmt.Errorf("Expected a pattern or weekdays in rule for category %s", category)
+	}
+
+	if len(rc.Match) > 0 {
+		re, err := regexp.Compile(rc.Match)
+		if err != nil {
+			return r, fmt.Errorf("Invalid pattern %q for category %s: %v", rc.Match, category, err)
+		}
+		r.pattern = re
+	}
+	if len(rc.Weekdays) > 0 {
+		r.weekdays = make(map[time.Weekday]bool)
+	}
+	for _, day := range rc.Weekdays {
+		wd, ok := parseWeekday(day)
+		if !ok {
+			return r, fmt.Errorf("Invalid weekday %q for category %s", day, category)
+		}
+		r.weekdays[wd] = true
+	}
+	r.debit, r.credit = rc.Debit, rc.Credit
+	r.payee, r.currency = rc.Payee, rc.Currency
+	r.priority = rc.Priority
+	return r, nil
+}
+
+// apply sets the account, and rewrites the payee and currency of the txn, as
+// specified by the rule.
+func (r rule) apply(t *Txn) {
+	if t.Cur > 0 {
+		t.From = r.accountFor(*t)
+	} else {
+		t.To = r.accountFor(*t)
+	}
+	if len(r.payee) > 0 {
+		t.Desc = r.payee
+	}
+	if len(r.currency) > 0 {
+		t.CurName = r.currency
+	}
+}
+
+// parseRuleList parses the list form of rules.yaml, where each rule specifies its
+// category via to.
+func parseRuleList(entries []interface{}) ([]rule, []error) {
+	var rules []rule
+	var errs []error
+	for i, v := range entries {
+		var rc ruleConf
+		data, err := yaml.Marshal(v)
+		if err == nil {
+			err = yaml.UnmarshalStrict(data, &rc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid rule %d: %v", i, err))
+			continue
+		}
+		if len(rc.To) == 0 {
+			errs = append(errs, fmt.Errorf("Expected to in rule %d", i))
+			continue
+		}
+		r, err := newRule(rc.To, rc)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, errs
+}
+
+// loadRules would parse a rules.yaml file in this format:
+// Expenses:Travel:
+//   - regexp-for-description
+//   - ^LYFT\ +\*RIDE
+// Expenses:Food:
+//   - ^STARBUCKS
+// Expenses:Gifts:
+//   - match: ^VENMO
+//     credit: Income:Gifts
+// Expenses:Transit:
+//   - match: ^OPAL
+//     weekdays: [mon, tue, wed, thu, fri]
+// ...
+// Alternatively, the file can be a list of condition objects, which specify the
+// category via to:
+// - match: ^LYFT
+//   to: Expenses:Travel
+//   payee: Lyft
+// ...
+// A rule is either a pattern, or a condition object. Condition objects can specify
+// separate accounts for debits and credits, which are used instead of the
+// category. They can also restrict the rule to txns on certain weekdays, in which
+// case the pattern is optional. Rules are evaluated in the order of their priority,
+// which defaults to 0, and then in the order they are declared in the file. The
+// first matching rule wins. All the patterns are compiled upfront. Every
+// invalid rule is returned as an error, so they can all be fixed in one go. A
+// missing file results in no rules.
+func loadRules(fpath string) ([]rule, []error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+
+	var list []interface{}
+	if err := yaml.Unmarshal(data, &list); err == nil {
+		rules, errs := parseRuleList(list)
+		return byPriority(rules), errs
+	}
+
+	// Use MapSlice to retain the declaration order of categories.
+	var raw yaml.MapSlice
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, []error{fmt.Errorf("Unable to parse rules at %s: %v", fpath, err)}
+	}
+
+	var rules []rule
+	var errs []error
+	for _, item := range raw {
+		category := fmt.Sprintf("%v", item.Key)
+		entries, ok := item.Value.([]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("Expected a list of rules for category %s", category))
+			continue
+		}
+		for _, v := range entries {
+			r, err := parseRule(category, v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			rules = append(rules, r)
+		}
+	}
+	return byPriority(rules), errs
+}
+
+// byPriority orders the rules by descending priority. Rules with the same
+// priority retain their declaration order.
+func byPriority(rules []rule) []rule {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].priority > rules[j].priority
+	})
+	return rules
+}
+
+// categorizeByRules would auto-categorize txns, if their description matches
+// any of the rules.
+func (p *parser) categorizeByRules(txns []Txn) []Txn {
+	if len(p.rules) == 0 {
+		return txns
+	}
+
+	matchingRule := func(t Txn) (rule, bool) {
+		for _, r := range p.rules {
+			if r.matches(t) {
+				return r, true
+			}
+		}
+		return rule{}, false
+	}
+
+	if *explainRules {
+		p.explainRules(txns)
+	}
+
+	unmatched := txns[:0]
+	var count int
+	for _, t := range txns {
+		if r, ok := matchingRule(t); ok {
+			r.apply(&t)
+			count++
+			printSummary(t, count, count)
+			p.writeToDB(t)
+		} else {
+			unmatched = append(unmatched, t)
+		}
+	}
+	fmt.Printf("\t%d txns have been categorized based on rules.\n\n", len(txns)-len(unmatched))
+	return unmatched
+}
+
+// explainRules prints the txns which match rules of more than one category, along
+// with the category which wins.
+func (p *parser) explainRules(txns []Txn) {
+	var count int
+	for _, t := range txns {
+		var cats []string
+		seen := make(map[string]bool)
+		for _, r := range p.rules {
+			cat := r.accountFor(t)
+			if !seen[cat] && r.matches(t) {
+				seen[cat] = true
+				cats = append(cats, cat)
+			}
+		}
+		if len(cats) < 2 {
+			continue
+		}
+		count++
+		printSummary(t, count, count)
+		fmt.Printf("\tMatches: %s. Using: %s\n", strings.Join(cats, ", "), cats[0])
+	}
+	fmt.Printf("\t%d txns match rules of more than one category.\n\n", count)
+}
+
+func (r rule) String() string {
+	var conds []string
+	if r.pattern != nil {
+		conds = append(conds, r.pattern.String())
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if r.weekdays[wd] {
+			conds = append(conds, wd.String()[:3])
+		}
+	}
+	return fmt.Sprintf("%s [%s]", r.category, strings.Join(conds, ", "))
+}
+
+// minBroadTxns is the number of txns in the journal needed to call a rule broad.
+// With fewer, a quarter of the txns is too few to tell.
+const minBroadTxns = 8
+
+// testRules reports the number of txns in the journal matched by each rule, and
+// the number of those for which the rule wins. Rules which never match are dead,
+// and rules which match over a quarter of the txns are likely too broad.
+func (p *parser) testRules(w io.Writer) {
+	matched := make([]int, len(p.rules))
+	won := make([]int, len(p.rules))
+	samples := make([][]string, len(p.rules))
+	for _, t := range p.txns {
+		first := true
+		for i, r := range p.rules {
+			if !r.matches(t) {
+				continue
+			}
+			matched[i]++
+			if first {
+				won[i]++
+				first = false
+			}
+			if len(samples[i]) < 3 {
+				samples[i] = append(samples[i], t.Desc)
+			}
+		}
+	}
+
+	for i, r := range p.rules {
+		var note string
+		switch {
+		case matched[i] == 0:
+			note = " DEAD"
+		case len(p.txns) >= minBroadTxns && matched[i] > len(p.txns)/4:
+			note = " BROAD"
+		}
+		fmt.Fprintf(w, "%s: matches %d, wins %d.%s\n", r, matched[i], won[i], note)
+		for _, desc := range samples[i] {
+			fmt.Fprintf(w, "\t%s\n", desc)
+		}
+	}
+	fmt.Fprintf(w, "Tested %d rules against %d txns.\n", len(p.rules), len(p.txns))
+}
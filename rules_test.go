@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRules writes the rules to a temporary rules.yaml, and returns its path
+// along with a function to clean it up.
+func writeRules(t *testing.T, data string) (string, func()) {
+	dir, err := ioutil.TempDir("", "into-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := path.Join(dir, "rules.yaml")
+	if err := ioutil.WriteFile(fpath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fpath, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		data  string
+		rules int
+		errs  int
+	}{
+		{"Expenses:Food:\n  - ^STARBUCKS\n  - ^PEETS\n", 2, 0},
+		{"Expenses:Food:\n  - ^STAR(BUCKS\n  - ^PEETS\n", 1, 1},
+		{"Expenses:Food:\n  - (\nExpenses:Travel:\n  - \"[LYFT\"\n", 0, 2},
+		{"Expenses:Food: ^STARBUCKS\n", 0, 1},
+		{"Expenses:Food:\n  - match: ^STARBUCKS\n    unknown: 1\n", 0, 1},
+		{"Expenses:Food:\n  - match: ^STARBUCKS\n    to: Expenses:Coffee\n  - ^PEETS\n", 1, 1},
+		{"- match: ^LYFT\n  to: Expenses:Travel\n- match: ^UBER\n", 1, 1},
+		{"- match: (\n  to: Expenses:Travel\n", 0, 1},
+		{"Expenses:Food: [\n", 0, 1},
+	}
+	for _, tc := range tests {
+		fpath, cleanup := writeRules(t, tc.data)
+		rules, errs := loadRules(fpath)
+		cleanup()
+		if len(rules) != tc.rules || len(errs) != tc.errs {
+			t.Errorf("%q: got %d rules and errors %v, want %d rules and %d errors",
+				tc.data, len(rules), errs, tc.rules, tc.errs)
+		}
+	}
+
+	if rules, errs := loadRules("/nonexistent/rules.yaml"); len(rules) > 0 || len(errs) > 0 {
+		t.Errorf("missing file: got rules %v and errors %v", rules, errs)
+	}
+}
+
+func TestLoadRulesOrder(t *testing.T) {
+	tests := []struct {
+		data string
+		desc string
+		want string
+	}{
+		// Declaration order of categories decides, for both forms.
+		{"Expenses:Coffee:\n  - STARBUCKS\nExpenses:Food:\n  - ^STAR\n", "STARBUCKS", "Expenses:Coffee"},
+		{"Expenses:Food:\n  - ^STAR\nExpenses:Coffee:\n  - STARBUCKS\n", "STARBUCKS", "Expenses:Food"},
+		{"- match: ^STAR\n  to: Expenses:Food\n- match: STARBUCKS\n  to: Expenses:Coffee\n",
+			"STARBUCKS", "Expenses:Food"},
+		// Priority overrides declaration order.
+		{"Expenses:Food:\n  - ^STAR\nExpenses:Coffee:\n  - match: STARBUCKS\n    priority: 1\n",
+			"STARBUCKS", "Expenses:Coffee"},
+		{"- match: ^STAR\n  to: Expenses:Food\n  priority: -1\n- match: STARBUCKS\n  to: Expenses:Coffee\n",
+			"STARBUCKS", "Expenses:Coffee"},
+		{"Expenses:Food:\n  - ^STAR\nExpenses:Coffee:\n  - STARBUCKS\n", "PEETS", ""},
+	}
+	for _, tc := range tests {
+		fpath, cleanup := writeRules(t, tc.data)
+		rules, errs := loadRules(fpath)
+		cleanup()
+		if len(errs) > 0 {
+			t.Fatalf("%q: got errors %v", tc.data, errs)
+		}
+		var got string
+		txn := Txn{Desc: tc.desc, Cur: -3}
+		for _, r := range rules {
+			if r.matches(txn) {
+				got = r.accountFor(txn)
+				break
+			}
+		}
+		if got != tc.want {
+			t.Errorf("%q: %s got category %q, want %q", tc.data, tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestRuleSign(t *testing.T) {
+	data := "Expenses:Gifts:\n" +
+		"  - match: ^VENMO\n    credit: Income:Gifts\n" +
+		"Expenses:Shopping:\n" +
+		"  - match: ^AMAZON\n    debit: Expenses:Shopping:Online\n    credit: Income:Refunds\n" +
+		"Expenses:Food:\n  - ^SAFEWAY\n"
+	fpath, cleanup := writeRules(t, data)
+	defer cleanup()
+	rules, errs := loadRules(fpath)
+	if len(errs) > 0 {
+		t.Fatalf("got errors %v", errs)
+	}
+
+	tests := []struct {
+		desc string
+		cur  float64
+		to   string
+		from string
+	}{
+		{"VENMO JOHN", -20, "Expenses:Gifts", ""},
+		{"VENMO JOHN", 20, "", "Income:Gifts"},
+		{"AMAZON MKTP", -35, "Expenses:Shopping:Online", ""},
+		{"AMAZON MKTP", 35, "", "Income:Refunds"},
+		{"SAFEWAY", -40, "Expenses:Food", ""},
+		{"SAFEWAY", 40, "", "Expenses:Food"},
+	}
+	for _, tc := range tests {
+		txn := Txn{Desc: tc.desc, Cur: tc.cur}
+		for _, r := range rules {
+			if r.matches(txn) {
+				r.apply(&txn)
+				break
+			}
+		}
+		if txn.To != tc.to || txn.From != tc.from {
+			t.Errorf("%s %.2f: got to %q from %q, want to %q from %q",
+				tc.desc, tc.cur, txn.To, txn.From, tc.to, tc.from)
+		}
+	}
+}
+
+func TestRuleWeekdays(t *testing.T) {
+	data := "Expenses:Transit:Work:\n" +
+		"  - match: ^OPAL\n    weekdays: [mon, tue, wed, thu, Friday]\n" +
+		"Expenses:Transit:\n  - ^OPAL\n" +
+		"Expenses:Food:Brunch:\n  - weekdays: [sat, sun]\n"
+	fpath, cleanup := writeRules(t, data)
+	defer cleanup()
+	rules, errs := loadRules(fpath)
+	if len(errs) > 0 {
+		t.Fatalf("got errors %v", errs)
+	}
+
+	// March 4, 2024 is a Monday.
+	date := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		desc string
+		date time.Time
+		want string
+	}{
+		{"OPAL TRIP", date(4), "Expenses:Transit:Work"},
+		{"OPAL TRIP", date(8), "Expenses:Transit:Work"},
+		{"OPAL TRIP", date(9), "Expenses:Transit"},
+		{"CAFE", date(10), "Expenses:Food:Brunch"},
+		{"CAFE", date(11), ""},
+	}
+	for _, tc := range tests {
+		txn := Txn{Desc: tc.desc, Date: tc.date, Cur: -5}
+		for _, r := range rules {
+			if r.matches(txn) {
+				r.apply(&txn)
+				break
+			}
+		}
+		if txn.To != tc.want {
+			t.Errorf("%s on %s: got %q, want %q", tc.desc, tc.date.Weekday(), txn.To, tc.want)
+		}
+	}
+
+	bad, cleanup := writeRules(t, "Expenses:Food:\n  - weekdays: [funday]\n")
+	defer cleanup()
+	if _, errs := loadRules(bad); len(errs) != 1 {
+		t.Errorf("invalid weekday: got errors %v, want 1", errs)
+	}
+}
+
+func TestTestRules(t *testing.T) {
+	data := "Expenses:Coffee:\n  - STARBUCKS\nExpenses:Food:\n  - ^STAR\n  - ^SAFEWAY\n" +
+		"Expenses:Travel:\n  - UBER\n"
+	fpath, cleanup := writeRules(t, data)
+	defer cleanup()
+	rules, errs := loadRules(fpath)
+	if len(errs) > 0 {
+		t.Fatalf("got errors %v", errs)
+	}
+	txn := func(desc string) Txn { return Txn{Desc: desc, Cur: -5} }
+
+	tests := []struct {
+		name string
+		txns []Txn
+		want []string
+	}{
+		{"small journal", []Txn{txn("STARBUCKS"), txn("SAFEWAY"), txn("STAR MARKET")}, []string{
+			"Expenses:Coffee [STARBUCKS]: matches 1, wins 1.\n",
+			"Expenses:Food [^STAR]: matches 2, wins 1.\n",
+			"Expenses:Food [^SAFEWAY]: matches 1, wins 1.\n",
+			"Expenses:Travel [UBER]: matches 0, wins 0. DEAD\n",
+			"Tested 4 rules against 3 txns.\n",
+		}},
+		{"large journal", []Txn{txn("STARBUCKS"), txn("STARBUCKS"), txn("STARBUCKS"),
+			txn("SAFEWAY"), txn("RENT"), txn("RENT"), txn("RENT"), txn("RENT")}, []string{
+			"Expenses:Coffee [STARBUCKS]: matches 3, wins 3. BROAD\n",
+			"Expenses:Food [^STAR]: matches 3, wins 0. BROAD\n",
+			"Expenses:Food [^SAFEWAY]: matches 1, wins 1.\n",
+			"Tested 4 rules against 8 txns.\n",
+		}},
+	}
+	for _, tc := range tests {
+		p := parser{rules: rules, txns: tc.txns}
+		var b bytes.Buffer
+		p.testRules(&b)
+		for _, want := range tc.want {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("%s: got report:\n%s\nwant it to contain: %q", tc.name, b.String(), want)
+			}
+		}
+	}
+}
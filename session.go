@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+)
+
+var session = flag.String("session", "", "Persist the categorized txns in this boltdb,"+
+	" instead of a temp file. If the run is interrupted, rerun with the same flags to"+
+	" resume from where it left off.")
+
+// assignSessionKeys derives the keys of the txns from their contents, so the same
+// txns get the same keys across runs. Identical txns are told apart by the order
+// in which they occur.
+func assignSessionKeys(txns []Txn) {
+	seen := make(map[string]int)
+	for i := range txns {
+		t := &txns[i]
+		id := fmt.Sprintf("%s|%s|%.2f", t.Date.Format(stamp), t.Desc, t.Cur)
+		seen[id]++
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", id, seen[id])))
+		t.Key = sum[:16]
+	}
+}
+
+// resumeSession drops the txns which were already categorized in an earlier run of
+// the session.
+func (p *parser) resumeSession(txns []Txn) []Txn {
+	done := make(map[string]bool)
+	for _, t := range p.iterateDB() {
+		done[string(t.Key)] = true
+	}
+	if len(done) == 0 {
+		return txns
+	}
+	final := txns[:0]
+	for _, t := range txns {
+		if !done[string(t.Key)] {
+			final = append(final, t)
+		}
+	}
+	fmt.Printf("\t%d txns were already categorized in session: %s\n\n",
+		len(txns)-len(final), *session)
+	return final
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+var (
+	stats = flag.Bool("stats", false, "Report classifier accuracy over the journal via"+
+		" k-fold cross-validation, and exit.")
+	folds = flag.Int("folds", 5, "Number of folds to use with -stats.")
+)
+
+type classStats struct {
+	class     string
+	actual    int            // txns which belong to this class.
+	predicted int            // txns for which this class was the top hit.
+	correct   int            // txns for which this class was correctly the top hit.
+	confused  map[string]int // wrongly predicted class -> count.
+}
+
+func (c *classStats) precision() float64 {
+	if c.predicted == 0 {
+		return 0
+	}
+	return float64(c.correct) / float64(c.predicted)
+}
+
+func (c *classStats) recall() float64 {
+	if c.actual == 0 {
+		return 0
+	}
+	return float64(c.correct) / float64(c.actual)
+}
+
+// crossValidate holds out each fold of the journal txns in turn, trains the
+// classifier on the rest, and measures how well the held out txns are
+// classified. The classifier is retrained over all txns before returning.
+func (p *parser) crossValidate(k int) {
+	var txns []Txn
+	for _, t := range p.txns {
+		if !t.skipClassification {
+			txns = append(txns, t)
+		}
+	}
+	assertf(k > 1, "Expected at least 2 folds. Got: %d", k)
+	assertf(len(txns) >= k, "Expected at least %d txns for %d folds. Got: %d", k, k, len(txns))
+
+	all := make(map[string]*classStats)
+	get := func(class string) *classStats {
+		cs, has := all[class]
+		if !has {
+			cs = &classStats{class: class, confused: make(map[string]int)}
+			all[class] = cs
+		}
+		return cs
+	}
+
+	var total, top1, top3 int
+	for fold := 0; fold < k; fold++ {
+		var train, test []Txn
+		for i, t := range txns {
+			if i%k == fold {
+				test = append(test, t)
+			} else {
+				train = append(train, t)
+			}
+		}
+		p.train(train)
+
+		for _, t := range test {
+			t.To = truncateAccount(t.To, *depth)
+			total++
+			pairs, _ := p.rank(t)
+			for i := 0; i < 3 && i < len(pairs); i++ {
+				if string(p.classes[pairs[i].pos]) != t.To {
+					continue
+				}
+				if i == 0 {
+					top1++
+				}
+				top3++
+				break
+			}
+
+			predicted := string(p.classes[pairs[0].pos])
+			get(t.To).actual++
+			get(predicted).predicted++
+			if predicted == t.To {
+				get(t.To).correct++
+			} else {
+				get(t.To).confused[predicted]++
+			}
+		}
+	}
+	p.train(p.txns)
+
+	fmt.Println()
+	fmt.Printf("Cross-validated %d txns over %d folds.\n", total, k)
+	fmt.Printf("Top-1 accuracy: %6.2f%%\n", 100*float64(top1)/float64(total))
+	fmt.Printf("Top-3 accuracy: %6.2f%%\n", 100*float64(top3)/float64(total))
+	fmt.Println()
+
+	list := make([]*classStats, 0, len(all))
+	for _, cs := range all {
+		list = append(list, cs)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].recall() != list[j].recall() {
+			return list[i].recall() > list[j].recall()
+		}
+		return list[i].class < list[j].class
+	})
+	fmt.Printf("%-40s %8s %8s %10s %8s\n", "Category", "Txns", "Correct", "Precision", "Recall")
+	for _, cs := range list {
+		fmt.Printf("%-40s %8d %8d %9.2f%% %7.2f%%\n", cs.class, cs.actual, cs.correct,
+			100*cs.precision(), 100*cs.recall())
+	}
+
+	fmt.Println()
+	fmt.Println("Worst categories and what they were confused with:")
+	var shown int
+	for i := len(list) - 1; i >= 0 && shown < 5; i-- {
+		cs := list[i]
+		if len(cs.confused) == 0 {
+			continue
+		}
+		shown++
+		type conf struct {
+			class string
+			count int
+		}
+		var confs []conf
+		for class, count := range cs.confused {
+			confs = append(confs, conf{class, count})
+		}
+		sort.Slice(confs, func(i, j int) bool { return confs[i].count > confs[j].count })
+		fmt.Printf("%s (recall %.2f%%)\n", cs.class, 100*cs.recall())
+		for j := 0; j < 3 && j < len(confs); j++ {
+			fmt.Printf("\t%4d -> %s\n", confs[j].count, confs[j].class)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// stopWords are dropped from descriptions, before they're classified.
+type stopWords struct {
+	words    map[string]bool
+	patterns []*regexp.Regexp
+	digits   int // Pure numeric tokens longer than this are dropped, if set.
+}
+
+type stopWordsConf struct {
+	Words     []string `yaml:"words"`
+	Patterns  []string `yaml:"patterns"`
+	MaxDigits int      `yaml:"max-digits"`
+}
+
+// stops is loaded once at startup, and applies to both training and prediction.
+var stops *stopWords
+
+// loadStopWords would parse a stopwords.yaml file in this format:
+// words: [pos, debit, purchase]
+// patterns:
+//   - ^ref\d+$
+// max-digits: 5
+// Words and patterns are matched against lower cased terms. A missing file
+// results in no stop words.
+func loadStopWords(fpath string) (*stopWords, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+	var sc stopWordsConf
+	if err := yaml.UnmarshalStrict(data, &sc); err != nil {
+		return nil, fmt.Errorf("Unable to parse stop words at %s: %v", fpath, err)
+	}
+	sw := &stopWords{words: make(map[string]bool), digits: sc.MaxDigits}
+	for _, w := range sc.Words {
+		sw.words[strings.ToLower(w)] = true
+	}
+	for _, p := range sc.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stop word pattern %q: %v", p, err)
+		}
+		sw.patterns = append(sw.patterns, re)
+	}
+	return sw, nil
+}
+
+func isNumeric(term string) bool {
+	for _, r := range term {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(term) > 0
+}
+
+func (sw *stopWords) isStop(term string) bool {
+	if sw.words[term] {
+		return true
+	}
+	if sw.digits > 0 && len(term) > sw.digits && isNumeric(term) {
+		return true
+	}
+	for _, re := range sw.patterns {
+		if re.MatchString(term) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter drops the stop words from terms, in place.
+func (sw *stopWords) filter(terms []string) []string {
+	if sw == nil {
+		return terms
+	}
+	final := terms[:0]
+	for _, term := range terms {
+		if !sw.isStop(term) {
+			final = append(final, term)
+		}
+	}
+	return final
+}
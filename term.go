@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// termState is the state of the terminal before singleCharMode, which saneMode
+// restores.
+var termState *term.State
+
+// singleCharMode disables input buffering, so keys can be read as they're
+// pressed, and doesn't display entered characters on the screen.
+func singleCharMode() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return
+	}
+	if termState == nil {
+		st, err := term.GetState(fd)
+		if err != nil {
+			return
+		}
+		termState = st
+	}
+	// Unlike term.MakeRaw, cbreak keeps output processing and signals intact. So,
+	// newlines still return the carriage, and Ctrl-C still interrupts.
+	cbreak(fd)
+}
+
+// saneMode restores the terminal to the state before singleCharMode.
+func saneMode() {
+	if termState != nil {
+		term.Restore(int(os.Stdin.Fd()), termState)
+	}
+}
+
+// restoreOnSignal restores the terminal before exiting on an interrupt, so echo
+// isn't left disabled.
+func restoreOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		saneMode()
+		fmt.Println()
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
+
+// fatalf restores the terminal, before logging and exiting with failure. All the
+// fatal errors must go via fatalf, as exiting skips the deferred saneMode.
+func fatalf(format string, args ...interface{}) {
+	saneMode()
+	log.Fatalf(format, args...)
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// cbreak isn't supported on this platform, so keys are read once a line is
+// entered.
+func cbreak(fd int) error {
+	return errors.New("cbreak is not supported on this platform")
+}
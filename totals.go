@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var showTotals = flag.Int("totals", 5, "Show the running totals of the top N categories"+
+	" during review. Zero disables them.")
+
+// trackTotal records the category postings of the txn written to db, replacing
+// any recorded earlier for it.
+func (p *parser) trackTotal(t Txn) {
+	if p.totals == nil {
+		p.totals = make(map[string][]Posting)
+	}
+	sign := 1.0
+	cat := t.To
+	if t.Cur > 0 {
+		// Credits flow out of the category.
+		sign, cat = -1.0, t.From
+	}
+	var postings []Posting
+	if len(t.Splits) > 0 {
+		for _, ps := range t.Splits {
+			postings = append(postings, Posting{ps.Account, sign * ps.Amount})
+		}
+	} else {
+		postings = append(postings, Posting{cat, sign * math.Abs(t.Cur)})
+	}
+	p.totals[string(t.Key)] = postings
+}
+
+// printTotals prints the top categories by the magnitude of their running totals.
+func (p *parser) printTotals() {
+	if *showTotals <= 0 || len(p.totals) == 0 {
+		return
+	}
+	fmt.Printf("%6s %s\n", "[SUM]", p.topTotals(*showTotals))
+}
+
+// topTotals returns the top n categories by the magnitude of their running
+// totals. Ties are ordered by category, so they stay put between redraws.
+func (p *parser) topTotals(n int) string {
+	sums := make(map[string]float64)
+	for _, postings := range p.totals {
+		for _, ps := range postings {
+			sums[ps.Account] += ps.Amount
+		}
+	}
+	accounts := make([]string, 0, len(sums))
+	for acc := range sums {
+		accounts = append(accounts, acc)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := math.Abs(sums[accounts[i]]), math.Abs(sums[accounts[j]])
+		if a != b {
+			return a > b
+		}
+		return accounts[i] < accounts[j]
+	})
+	if len(accounts) > n {
+		accounts = accounts[:n]
+	}
+	parts := make([]string, 0, len(accounts))
+	for _, acc := range accounts {
+		parts = append(parts, fmt.Sprintf("%s %.2f", acc, sums[acc]))
+	}
+	return strings.Join(parts, " | ")
+}
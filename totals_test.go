@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestTopTotals(t *testing.T) {
+	p := parser{totals: map[string][]Posting{
+		"k1": {{"Expenses:Food", 30}},
+		"k2": {{"Expenses:Travel", 30}},
+		"k3": {{"Expenses:Coffee", 5}, {"Expenses:Food", -10}},
+		"k4": {{"Expenses:Home", 20}},
+		"k5": {{"Income:Refunds", -30}},
+	}}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "Expenses:Travel 30.00"},
+		{3, "Expenses:Travel 30.00 | Income:Refunds -30.00 | Expenses:Food 20.00"},
+		{5, "Expenses:Travel 30.00 | Income:Refunds -30.00 | Expenses:Food 20.00 |" +
+			" Expenses:Home 20.00 | Expenses:Coffee 5.00"},
+	}
+	for _, tc := range tests {
+		// Map iteration order varies, so repeat to catch unstable ties.
+		for i := 0; i < 10; i++ {
+			if got := p.topTotals(tc.n); got != tc.want {
+				t.Fatalf("top %d: got %q, want %q", tc.n, got, tc.want)
+			}
+		}
+	}
+}
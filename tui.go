@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var (
+	tui      = flag.Bool("tui", false, "Review txns in a scrollable list, instead of one at a time.")
+	tuiLines = flag.Int("tui-lines", 20, "Number of txns shown at once in -tui mode.")
+)
+
+// listAndCategorizeTxns shows the txns as a scrollable list, with the proposed
+// categories inline. Any txn can be picked to be accepted, or categorized.
+func (p *parser) listAndCategorizeTxns(txns []Txn) {
+	if len(txns) == 0 {
+		return
+	}
+	for i := range txns {
+		p.classifyTxn(&txns[i])
+	}
+
+	page := *tuiLines
+	if page < 1 {
+		page = 1
+	}
+	var cur int
+	for {
+		if cur < 0 {
+			cur = 0
+		}
+		if cur >= len(txns) {
+			cur = len(txns) - 1
+		}
+		start := cur - page/2
+		if start+page > len(txns) {
+			start = len(txns) - page
+		}
+		if start < 0 {
+			start = 0
+		}
+
+		clear()
+		for i := start; i < len(txns) && i < start+page; i++ {
+			if i == cur {
+				fmt.Printf("> ")
+			} else {
+				fmt.Printf("  ")
+			}
+			printSummary(txns[i], i, len(txns))
+		}
+		fmt.Println()
+		fmt.Println("j/k: down/up, J/K: page down/up, enter: accept, c: categorize," +
+			" u: undo, x: skip, w: commit and finish")
+
+		key, ok := readKey()
+		if !ok {
+			return
+		}
+		t := &txns[cur]
+		switch key {
+		case 'j':
+			cur++
+		case 'k':
+			cur--
+		case 'J':
+			cur += page
+		case 'K':
+			cur -= page
+		case 'x':
+			cur++
+		case '\n':
+			if len(t.To) > 0 && len(t.From) > 0 {
+				p.writeToDB(*t)
+				t.Done = true
+				cur++
+			}
+		case 'c':
+			p.categorizeTxn(t, cur, len(txns))
+			if t.Done {
+				cur++
+			}
+		case 'u':
+			if t.Done {
+				p.deleteFromDB(t.Key)
+				t.Done = false
+			}
+		case 'w', 'q':
+			return
+		}
+	}
+}